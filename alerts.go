@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var alertRulesKey = "alertRules"
+
+const (
+	alertAbove     = "above"
+	alertBelow     = "below"
+	alertIncreases = "increases"
+)
+
+// alertRule fires when an observation field crosses a threshold, given in the
+// unit published by weatherflow2mqtt.
+type alertRule struct {
+	Field      string  `json:"field"`
+	Comparison string  `json:"comparison"`
+	Threshold  float64 `json:"threshold"`  // minimum increase for alertIncreases
+	Hysteresis float64 `json:"hysteresis"` // margin to cross back before the rule can fire again
+	Cooldown   int     `json:"cooldown"`   // minutes between two notifications
+	Within     float64 `json:"within"`     // when set, only fire for lightning closer than this in km
+}
+
+var defaultAlertRules = []alertRule{
+	{Field: "wind_gust", Comparison: alertAbove, Threshold: 60, Hysteresis: 5, Cooldown: 30},
+	{Field: "air_temperature", Comparison: alertBelow, Threshold: 0, Hysteresis: 1, Cooldown: 60},
+	{Field: "lightning_strike_count", Comparison: alertIncreases, Cooldown: 15, Within: 10},
+}
+
+func (r alertRule) String() string {
+	s := fmt.Sprintf("%s %s %g", r.Field, r.Comparison, r.Threshold)
+	if r.Within > 0 {
+		s += fmt.Sprintf(" within %g km", r.Within)
+	}
+	return s
+}
+
+type alertState struct {
+	exceeded bool // past the threshold, until crossing back beyond the hysteresis
+	active   bool // exceeded, by lightning close enough when the rule has a distance
+	notified bool // an alert already fired for the current crossing
+	last     float64
+	seen     bool
+	fired    time.Time
+}
+
+// alertEvent is a rule that just fired.
+type alertEvent struct {
+	rule  alertRule
+	value float64
+}
+
+// alertEngine evaluates the rules against the observations of one station.
+type alertEngine struct {
+	lock   sync.Mutex
+	rules  []alertRule
+	states []alertState
+}
+
+func newAlertEngine(rules []alertRule) *alertEngine {
+	e := &alertEngine{}
+	e.setRules(rules)
+	return e
+}
+
+func (e *alertEngine) setRules(rules []alertRule) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.rules = append([]alertRule(nil), rules...)
+	e.states = make([]alertState, len(rules))
+}
+
+// evaluate updates the rules with an observation and returns the ones firing.
+func (e *alertEngine) evaluate(t time.Time, values map[string]float64) []alertEvent {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	events := []alertEvent{}
+	for i, rule := range e.rules {
+		v, ok := values[rule.Field]
+		if !ok {
+			continue
+		}
+		state := &e.states[i]
+
+		switch rule.Comparison {
+		case alertAbove:
+			if v > rule.Threshold {
+				state.exceeded = true
+			} else if v <= rule.Threshold-rule.Hysteresis {
+				state.exceeded = false
+			}
+		case alertBelow:
+			if v < rule.Threshold {
+				state.exceeded = true
+			} else if v >= rule.Threshold+rule.Hysteresis {
+				state.exceeded = false
+			}
+		case alertIncreases:
+			// Every increase is a new crossing
+			state.exceeded = state.seen && v-state.last > rule.Threshold
+			state.notified = false
+		}
+		state.last, state.seen = v, true
+		if !state.exceeded {
+			state.notified = false
+		}
+
+		state.active = state.exceeded
+		if state.active && rule.Within > 0 {
+			distance, ok := values["lightning_strike_distance"]
+			state.active = ok && distance <= rule.Within
+		}
+
+		// A crossing during the cooldown fires once it is over, if still active
+		if !state.active || state.notified || t.Sub(state.fired) < time.Duration(rule.Cooldown)*time.Minute {
+			continue
+		}
+
+		state.fired, state.notified = t, true
+		events = append(events, alertEvent{rule: rule, value: v})
+	}
+
+	return events
+}
+
+// active returns the fields having at least one rule currently exceeded.
+func (e *alertEngine) active() map[string]bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	fields := map[string]bool{}
+	for i, rule := range e.rules {
+		if e.states[i].active {
+			fields[rule.Field] = true
+		}
+	}
+	return fields
+}
+
+func (app *application) alertRules() []alertRule {
+	saved := app.app.Preferences().String(alertRulesKey)
+	if saved == "" {
+		return defaultAlertRules
+	}
+
+	rules := []alertRule{}
+	if err := json.Unmarshal([]byte(saved), &rules); err != nil {
+		fyne.LogError("Unable to read the alert rules", err)
+		return defaultAlertRules
+	}
+	return rules
+}
+
+func (app *application) saveAlertRules(rules []alertRule) {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		fyne.LogError("Unable to save the alert rules", err)
+		return
+	}
+	app.app.Preferences().SetString(alertRulesKey, string(data))
+
+	for _, card := range app.cardList() {
+		card.alerts.setRules(rules)
+	}
+}
+
+// notifyAlert sends a desktop notification for a rule that fired on a station.
+func (app *application) notifyAlert(serial string, event alertEvent) {
+	app.app.SendNotification(fyne.NewNotification(stationTitle(serial)+" alert",
+		fmt.Sprintf("%s (now %g)", event.rule, event.value)))
+}
+
+// numericFieldKeys lists the observation fields a rule can watch.
+func numericFieldKeys() []string {
+	keys := []string{}
+	for _, section := range cardSections {
+		for _, field := range section.fields {
+			if field.format != "" {
+				keys = append(keys, field.key)
+			}
+		}
+	}
+	return keys
+}
+
+// alertRuleRow edits one rule of the alerts dialog.
+type alertRuleRow struct {
+	field, comparison                       *widget.Select
+	threshold, hysteresis, cooldown, within *widget.Entry
+	content                                 fyne.CanvasObject
+}
+
+func newAlertRuleRow(rule alertRule, remove func(*alertRuleRow)) *alertRuleRow {
+	number := func(v float64, placeholder string) *widget.Entry {
+		e := widget.NewEntry()
+		e.SetPlaceHolder(placeholder)
+		e.SetText(strconv.FormatFloat(v, 'f', -1, 64))
+		e.Validator = func(text string) error {
+			_, err := strconv.ParseFloat(text, 64)
+			return err
+		}
+		return e
+	}
+
+	row := &alertRuleRow{
+		field:      widget.NewSelect(numericFieldKeys(), nil),
+		comparison: widget.NewSelect([]string{alertAbove, alertBelow, alertIncreases}, nil),
+		threshold:  number(rule.Threshold, "Threshold"),
+		hysteresis: number(rule.Hysteresis, "Hysteresis"),
+		cooldown:   number(float64(rule.Cooldown), "Cooldown"),
+		within:     number(rule.Within, "Within km"),
+	}
+	row.field.SetSelected(rule.Field)
+	row.comparison.SetSelected(rule.Comparison)
+
+	deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() { remove(row) })
+	row.content = container.NewBorder(nil, nil, nil, deleteButton, container.NewVBox(
+		container.NewGridWithColumns(2, row.field, row.comparison),
+		container.NewGridWithColumns(4, row.threshold, row.hysteresis, row.cooldown, row.within)))
+
+	return row
+}
+
+func (row *alertRuleRow) rule() (alertRule, error) {
+	values := make([]float64, 4)
+	for i, e := range []*widget.Entry{row.threshold, row.hysteresis, row.cooldown, row.within} {
+		v, err := strconv.ParseFloat(e.Text, 64)
+		if err != nil {
+			return alertRule{}, fmt.Errorf("invalid %s for %s: %w", e.PlaceHolder, row.field.Selected, err)
+		}
+		values[i] = v
+	}
+
+	return alertRule{Field: row.field.Selected, Comparison: row.comparison.Selected,
+		Threshold: values[0], Hysteresis: values[1], Cooldown: int(values[2]), Within: values[3]}, nil
+}
+
+func (app *application) alertsDialogShow() {
+	rows := []*alertRuleRow{}
+	list := container.NewVBox()
+
+	var remove func(*alertRuleRow)
+	add := func(rule alertRule) {
+		row := newAlertRuleRow(rule, remove)
+		rows = append(rows, row)
+		list.Add(row.content)
+	}
+	remove = func(row *alertRuleRow) {
+		for i, r := range rows {
+			if r == row {
+				rows = append(rows[:i], rows[i+1:]...)
+				break
+			}
+		}
+		list.Remove(row.content)
+	}
+
+	for _, rule := range app.alertRules() {
+		add(rule)
+	}
+
+	addRule := widget.NewButtonWithIcon("Add rule", theme.ContentAddIcon(), func() {
+		add(alertRule{Field: "wind_gust", Comparison: alertAbove, Cooldown: 30})
+	})
+	hint := widget.NewLabel("Threshold, hysteresis, cooldown (minutes), lightning within (km, 0 for any).\nValues use the units published by the station.")
+	hint.Wrapping = fyne.TextWrapWord
+
+	d := dialog.NewCustomConfirm("Alerts", "Apply", "Cancel",
+		container.NewBorder(hint, addRule, nil, nil, container.NewVScroll(list)), func(ok bool) {
+			if !ok {
+				return
+			}
+
+			rules := []alertRule{}
+			for _, row := range rows {
+				rule, err := row.rule()
+				if err != nil {
+					dialog.ShowError(err, app.window)
+					return
+				}
+				rules = append(rules, rule)
+			}
+			app.saveAlertRules(rules)
+		}, app.window)
+	d.Resize(fyne.NewSize(500, 450))
+	d.Show()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertEngineEvaluate(t *testing.T) {
+	type step struct {
+		minute int
+		values map[string]float64
+		fired  bool
+	}
+
+	for name, tt := range map[string]struct {
+		rule  alertRule
+		steps []step
+	}{
+		"above with hysteresis": {
+			alertRule{Field: "wind_gust", Comparison: alertAbove, Threshold: 60, Hysteresis: 5},
+			[]step{
+				{0, map[string]float64{"wind_gust": 50}, false},
+				{1, map[string]float64{"wind_gust": 61}, true},
+				{2, map[string]float64{"wind_gust": 65}, false}, // still above
+				{3, map[string]float64{"wind_gust": 58}, false}, // inside the hysteresis
+				{4, map[string]float64{"wind_gust": 62}, false},
+				{5, map[string]float64{"wind_gust": 55}, false}, // crossed back
+				{6, map[string]float64{"wind_gust": 61}, true},
+				{7, map[string]float64{"air_temperature": 80}, false},
+			},
+		},
+		"below with hysteresis": {
+			alertRule{Field: "air_temperature", Comparison: alertBelow, Threshold: 0, Hysteresis: 1},
+			[]step{
+				{0, map[string]float64{"air_temperature": 2}, false},
+				{1, map[string]float64{"air_temperature": -0.5}, true},
+				{2, map[string]float64{"air_temperature": 0.5}, false},
+				{3, map[string]float64{"air_temperature": -1}, false},
+				{4, map[string]float64{"air_temperature": 1}, false},
+				{5, map[string]float64{"air_temperature": -1}, true},
+			},
+		},
+		"increases within": {
+			alertRule{Field: "lightning_strike_count", Comparison: alertIncreases, Within: 10},
+			[]step{
+				{0, map[string]float64{"lightning_strike_count": 3, "lightning_strike_distance": 5}, false}, // first value seen
+				{1, map[string]float64{"lightning_strike_count": 3, "lightning_strike_distance": 5}, false},
+				{2, map[string]float64{"lightning_strike_count": 4, "lightning_strike_distance": 25}, false}, // too far
+				{3, map[string]float64{"lightning_strike_count": 5}, false},                                  // distance unknown
+				{4, map[string]float64{"lightning_strike_count": 6, "lightning_strike_distance": 10}, true},
+				{5, map[string]float64{"lightning_strike_count": 8, "lightning_strike_distance": 2}, true},
+			},
+		},
+		"increases by threshold": {
+			alertRule{Field: "rain_today", Comparison: alertIncreases, Threshold: 2},
+			[]step{
+				{0, map[string]float64{"rain_today": 1}, false},
+				{1, map[string]float64{"rain_today": 3}, false},
+				{2, map[string]float64{"rain_today": 5.5}, true},
+			},
+		},
+		"cooldown": {
+			alertRule{Field: "wind_gust", Comparison: alertAbove, Threshold: 60, Cooldown: 30},
+			[]step{
+				{0, map[string]float64{"wind_gust": 70}, true},
+				{1, map[string]float64{"wind_gust": 50}, false},
+				{10, map[string]float64{"wind_gust": 70}, false}, // crossed again during the cooldown
+				{20, map[string]float64{"wind_gust": 50}, false},
+				{30, map[string]float64{"wind_gust": 70}, true},
+			},
+		},
+		"crossing during the cooldown": {
+			alertRule{Field: "wind_gust", Comparison: alertAbove, Threshold: 60, Hysteresis: 5, Cooldown: 30},
+			[]step{
+				{0, map[string]float64{"wind_gust": 70}, true},
+				{1, map[string]float64{"wind_gust": 50}, false},
+				{10, map[string]float64{"wind_gust": 70}, false}, // still in the cooldown
+				{20, map[string]float64{"wind_gust": 58}, false}, // inside the hysteresis
+				{30, map[string]float64{"wind_gust": 70}, true},  // cooldown over and still above
+				{31, map[string]float64{"wind_gust": 75}, false},
+			},
+		},
+		"above within": {
+			alertRule{Field: "lightning_strike_energy", Comparison: alertAbove, Threshold: 100, Within: 10},
+			[]step{
+				{0, map[string]float64{"lightning_strike_energy": 200, "lightning_strike_distance": 30}, false},
+				{1, map[string]float64{"lightning_strike_energy": 200, "lightning_strike_distance": 8}, true},
+				{2, map[string]float64{"lightning_strike_energy": 200, "lightning_strike_distance": 5}, false},
+			},
+		},
+	} {
+		e := newAlertEngine([]alertRule{tt.rule})
+		start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		for i, s := range tt.steps {
+			events := e.evaluate(start.Add(time.Duration(s.minute)*time.Minute), s.values)
+			if fired := len(events) > 0; fired != s.fired {
+				t.Errorf("%s: step %d fired = %v, want %v", name, i, fired, s.fired)
+			}
+			for _, event := range events {
+				if event.value != s.values[tt.rule.Field] {
+					t.Errorf("%s: step %d fired with %g", name, i, event.value)
+				}
+			}
+		}
+	}
+}
+
+func TestAlertEngineActive(t *testing.T) {
+	e := newAlertEngine(defaultAlertRules)
+	now := time.Now()
+
+	for i, tt := range []struct {
+		values map[string]float64
+		want   map[string]bool
+	}{
+		{map[string]float64{"wind_gust": 70, "air_temperature": 5, "lightning_strike_count": 1, "lightning_strike_distance": 5}, map[string]bool{"wind_gust": true}},
+		// A far strike does not make the rule active
+		{map[string]float64{"wind_gust": 58, "lightning_strike_count": 2, "lightning_strike_distance": 25}, map[string]bool{"wind_gust": true}},
+		{map[string]float64{"wind_gust": 50, "lightning_strike_count": 3, "lightning_strike_distance": 5}, map[string]bool{"lightning_strike_count": true}},
+		// Still active during the cooldown
+		{map[string]float64{"lightning_strike_count": 4, "lightning_strike_distance": 5, "air_temperature": -1}, map[string]bool{"lightning_strike_count": true, "air_temperature": true}},
+		{map[string]float64{"lightning_strike_count": 4, "lightning_strike_distance": 5}, map[string]bool{"air_temperature": true}},
+	} {
+		e.evaluate(now.Add(time.Duration(i)*time.Minute), tt.values)
+		active := e.active()
+		if len(active) != len(tt.want) {
+			t.Errorf("step %d: active fields = %v, want %v", i, active, tt.want)
+			continue
+		}
+		for field := range tt.want {
+			if !active[field] {
+				t.Errorf("step %d: active fields = %v, want %v", i, active, tt.want)
+			}
+		}
+	}
+}
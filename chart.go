@@ -0,0 +1,244 @@
+package main
+
+import (
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var minChartSpan = 10 * time.Minute
+
+// lineChart plots samples over time, either as a small sparkline or, when
+// detailed, with the value range and time span around the plot.
+type lineChart struct {
+	widget.BaseWidget
+
+	samples  []sample
+	span     time.Duration // visible time window ending at the last sample, 0 shows everything
+	detailed bool
+	format   func(float64) string
+
+	OnTapped func()
+}
+
+func newSparkline(onTapped func()) *lineChart {
+	c := &lineChart{OnTapped: onTapped}
+	c.ExtendBaseWidget(c)
+	return c
+}
+
+func newDetailedChart(samples []sample, format func(float64) string) *lineChart {
+	c := &lineChart{samples: samples, detailed: true, format: format}
+	c.ExtendBaseWidget(c)
+	return c
+}
+
+func (c *lineChart) SetSamples(samples []sample) {
+	c.samples = samples
+	c.Refresh()
+}
+
+func (c *lineChart) Tapped(*fyne.PointEvent) {
+	if c.OnTapped != nil {
+		c.OnTapped()
+	}
+}
+
+// zoom divides the visible time window by factor, a factor below 1 zooms out.
+func (c *lineChart) zoom(factor float64) {
+	if len(c.samples) < 2 {
+		return
+	}
+	total := c.samples[len(c.samples)-1].Time.Sub(c.samples[0].Time)
+
+	span := c.span
+	if span == 0 {
+		span = total
+	}
+	span = time.Duration(float64(span) / factor)
+
+	switch {
+	case span < minChartSpan:
+		span = minChartSpan
+	case span >= total:
+		span = 0
+	}
+
+	c.span = span
+	c.Refresh()
+}
+
+// visible returns the samples inside the visible time window.
+func (c *lineChart) visible() []sample {
+	if c.span == 0 || len(c.samples) == 0 {
+		return c.samples
+	}
+
+	start := c.samples[len(c.samples)-1].Time.Add(-c.span)
+	for i, s := range c.samples {
+		if !s.Time.Before(start) {
+			return c.samples[i:]
+		}
+	}
+	return nil
+}
+
+func (c *lineChart) CreateRenderer() fyne.WidgetRenderer {
+	r := &chartRenderer{chart: c,
+		max:   canvas.NewText("", theme.Color(theme.ColorNameForeground)),
+		min:   canvas.NewText("", theme.Color(theme.ColorNameForeground)),
+		start: canvas.NewText("", theme.Color(theme.ColorNameForeground)),
+		end:   canvas.NewText("", theme.Color(theme.ColorNameForeground)),
+	}
+	for _, text := range []*canvas.Text{r.max, r.min, r.start, r.end} {
+		text.TextSize = theme.CaptionTextSize()
+	}
+	r.end.Alignment = fyne.TextAlignTrailing
+
+	r.Refresh()
+	return r
+}
+
+type chartRenderer struct {
+	chart *lineChart
+
+	lines                []*canvas.Line
+	used                 int
+	max, min, start, end *canvas.Text
+}
+
+func (r *chartRenderer) Destroy() {}
+
+func (r *chartRenderer) MinSize() fyne.Size {
+	if r.chart.detailed {
+		return fyne.NewSize(300, 200)
+	}
+	return fyne.NewSize(80, theme.TextSize()+theme.Padding())
+}
+
+func (r *chartRenderer) Objects() []fyne.CanvasObject {
+	objects := []fyne.CanvasObject{}
+	for _, line := range r.lines[:r.used] {
+		objects = append(objects, line)
+	}
+	if r.chart.detailed {
+		objects = append(objects, r.max, r.min, r.start, r.end)
+	}
+	return objects
+}
+
+func (r *chartRenderer) Layout(size fyne.Size) {
+	samples := r.chart.visible()
+	plot := fyne.NewPos(0, 0)
+	plotSize := size
+
+	low, high := 0.0, 1.0
+	if len(samples) > 0 {
+		low, high = samples[0].Value, samples[0].Value
+		for _, s := range samples {
+			low, high = min(low, s.Value), max(high, s.Value)
+		}
+		if low == high {
+			low, high = low-1, high+1
+		}
+	}
+
+	if r.chart.detailed && len(samples) > 0 {
+		r.max.Text, r.min.Text = r.chart.format(high), r.chart.format(low)
+		r.start.Text = samples[0].Time.Format("Jan 2 15:04")
+		r.end.Text = samples[len(samples)-1].Time.Format("Jan 2 15:04")
+
+		label := r.max.MinSize().Height
+		left := fyne.Max(r.max.MinSize().Width, r.min.MinSize().Width) + theme.Padding()
+		plot = fyne.NewPos(left, label/2)
+		plotSize = fyne.NewSize(size.Width-left, size.Height-label*2)
+
+		r.max.Move(fyne.NewPos(0, 0))
+		r.min.Move(fyne.NewPos(0, plot.Y+plotSize.Height-label))
+		r.start.Move(fyne.NewPos(left, plot.Y+plotSize.Height+label/2))
+		r.end.Move(fyne.NewPos(size.Width-r.end.MinSize().Width, plot.Y+plotSize.Height+label/2))
+		for _, text := range []*canvas.Text{r.max, r.min, r.start, r.end} {
+			text.Resize(text.MinSize())
+		}
+	}
+
+	r.used = 0
+	if len(samples) < 2 {
+		return
+	}
+
+	first, last := samples[0].Time, samples[len(samples)-1].Time
+	duration := float32(last.Sub(first))
+	step := max(1, len(samples)/int(max(1, plotSize.Width/2)))
+
+	point := func(s sample) fyne.Position {
+		// Samples all received at the same time are drawn at the end of the plot
+		x := plotSize.Width
+		if duration > 0 {
+			x = float32(s.Time.Sub(first)) / duration * plotSize.Width
+		}
+		y := plotSize.Height - float32((s.Value-low)/(high-low))*plotSize.Height
+		return fyne.NewPos(plot.X+x, plot.Y+y)
+	}
+
+	previous := point(samples[0])
+	for i := step; i < len(samples); i += step {
+		next := point(samples[i])
+		if i+step >= len(samples) {
+			next = point(samples[len(samples)-1])
+		}
+
+		line := r.line()
+		line.Position1, line.Position2 = previous, next
+		previous = next
+	}
+}
+
+// line returns the next unused line of the plot.
+func (r *chartRenderer) line() *canvas.Line {
+	if r.used == len(r.lines) {
+		line := canvas.NewLine(theme.Color(theme.ColorNamePrimary))
+		line.StrokeWidth = 1.5
+		r.lines = append(r.lines, line)
+	}
+
+	r.used++
+	return r.lines[r.used-1]
+}
+
+func (r *chartRenderer) Refresh() {
+	r.Layout(r.chart.Size())
+
+	for _, line := range r.lines[:r.used] {
+		line.StrokeColor = theme.Color(theme.ColorNamePrimary)
+	}
+	for _, text := range []*canvas.Text{r.max, r.min, r.start, r.end} {
+		text.Color = theme.Color(theme.ColorNameForeground)
+	}
+	for _, o := range r.Objects() {
+		o.Refresh()
+	}
+}
+
+// historyChartShow opens a larger chart of the recorded values of a field.
+func (app *application) historyChartShow(title string, samples []sample, format func(float64) string) {
+	chart := newDetailedChart(samples, format)
+
+	zoom := container.NewHBox(
+		widget.NewButtonWithIcon("", theme.ZoomInIcon(), func() { chart.zoom(2) }),
+		widget.NewButtonWithIcon("", theme.ZoomOutIcon(), func() { chart.zoom(0.5) }),
+		widget.NewButtonWithIcon("", theme.ZoomFitIcon(), func() {
+			chart.span = 0
+			chart.Refresh()
+		}),
+	)
+
+	d := dialog.NewCustom(title, "Close", container.NewBorder(zoom, nil, nil, nil, chart), app.window)
+	d.Resize(fyne.NewSize(640, 420))
+	d.Show()
+}
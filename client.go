@@ -0,0 +1,212 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2/data/binding"
+	xbinding "fyne.io/x/fyne/data/binding"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	protocolV311 = "3.1.1"
+	protocolV5   = "5"
+)
+
+// mqttHandler receives the messages of a subscription.
+type mqttHandler func(msg mqtt.Message)
+
+// mqttClient is the part of an MQTT client used by the application, provided
+// by an adapter for each protocol version.
+type mqttClient interface {
+	Connect() mqtt.Token
+	Disconnect(quiesce uint)
+	IsConnected() bool
+	Subscribe(topic string, qos byte, handler mqttHandler) mqtt.Token
+	Unsubscribe(topics ...string) mqtt.Token
+
+	// CleanSession reports whether the broker forgets the subscriptions when
+	// the connection drops.
+	CleanSession() bool
+}
+
+// connectionEvents are called by the clients as their connection changes,
+// with the client reporting it.
+type connectionEvents struct {
+	connected    func(client mqttClient)
+	lost         func(client mqttClient, err error)
+	reconnecting func(client mqttClient)
+}
+
+// newMqttClient returns a client speaking the protocol selected in the profile,
+// configured from the common paho options.
+func newMqttClient(opts *mqtt.ClientOptions, profile connectionProfile, events connectionEvents) mqttClient {
+	if profile.Protocol == protocolV5 {
+		return newV5Client(opts, profile, events)
+	}
+
+	return newV3Client(opts, events)
+}
+
+var _ mqttClient = (*v3Client)(nil)
+
+// v3Client adapts the paho MQTT 3.1.1 client to the mqttClient interface.
+type v3Client struct {
+	client mqtt.Client
+	clean  bool
+}
+
+func newV3Client(opts *mqtt.ClientOptions, events connectionEvents) *v3Client {
+	c := &v3Client{clean: opts.CleanSession}
+
+	if events.connected != nil {
+		opts.SetOnConnectHandler(func(mqtt.Client) { events.connected(c) })
+	}
+	if events.lost != nil {
+		opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) { events.lost(c, err) })
+	}
+	if events.reconnecting != nil {
+		opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) { events.reconnecting(c) })
+	}
+
+	c.client = mqtt.NewClient(opts)
+	return c
+}
+
+func (c *v3Client) Connect() mqtt.Token {
+	return c.client.Connect()
+}
+
+func (c *v3Client) Disconnect(quiesce uint) {
+	c.client.Disconnect(quiesce)
+}
+
+func (c *v3Client) IsConnected() bool {
+	return c.client.IsConnected()
+}
+
+func (c *v3Client) Subscribe(topic string, qos byte, handler mqttHandler) mqtt.Token {
+	return c.client.Subscribe(topic, qos, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(msg)
+	})
+}
+
+func (c *v3Client) Unsubscribe(topics ...string) mqtt.Token {
+	return c.client.Unsubscribe(topics...)
+}
+
+func (c *v3Client) CleanSession() bool {
+	return c.clean
+}
+
+// mqttString is a String binding set to the last payload received on a topic.
+type mqttString struct {
+	binding.String
+
+	lock   sync.Mutex
+	client mqttClient
+	topic  string
+}
+
+// newMqttString subscribes to topic, call Close to unsubscribe.
+func newMqttString(client mqttClient, topic string) (xbinding.StringCloser, error) {
+	s := &mqttString{String: binding.NewString(), client: client, topic: topic}
+
+	t := client.Subscribe(topic, 1, func(msg mqtt.Message) {
+		s.String.Set(string(msg.Payload()))
+	})
+	if t.Wait() && t.Error() != nil {
+		return nil, t.Error()
+	}
+
+	return s, nil
+}
+
+func (s *mqttString) Close() error {
+	s.lock.Lock()
+	client := s.client
+	s.client = nil
+	s.lock.Unlock()
+
+	if client != nil {
+		client.Unsubscribe(s.topic)
+	}
+	return nil
+}
+
+// topicMatches reports whether topic is matched by the subscription filter,
+// handling the + and # wildcards and MQTT 5 shared subscriptions.
+func topicMatches(filter, topic string) bool {
+	if strings.HasPrefix(filter, "$share/") {
+		parts := strings.SplitN(filter, "/", 3)
+		if len(parts) < 3 {
+			return false
+		}
+		filter = parts[2]
+	}
+
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+
+	return len(f) == len(t)
+}
+
+// token implements mqtt.Token for operations that are not run by paho v3.
+type token struct {
+	done chan struct{}
+	err  error
+	once sync.Once
+}
+
+func newToken() *token {
+	return &token{done: make(chan struct{})}
+}
+
+// complete finishes the operation, only the first call has an effect.
+func (t *token) complete(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+func (t *token) Wait() bool {
+	<-t.done
+	return true
+}
+
+func (t *token) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (t *token) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *token) Error() error {
+	select {
+	case <-t.done:
+		return t.err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestTopicMatches(t *testing.T) {
+	for _, tt := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"weather/ST-1/observation", "weather/ST-1/observation", true},
+		{"weather/ST-1/observation", "weather/ST-2/observation", false},
+		{"weather/+/observation", "weather/ST-1/observation", true},
+		{"weather/+/observation", "weather/ST-1/status", false},
+		{"weather/+", "weather/ST-1/observation", false},
+		{"weather/#", "weather/ST-1/observation", true},
+		{"weather/#", "weather", true},
+		{"#", "weather/ST-1", true},
+		{"weather/ST-1", "weather/ST-1/observation", false},
+		{"weather/ST-1/observation", "weather/ST-1", false},
+		{"$share/group/weather/+/status", "weather/ST-1/status", true},
+		{"$share/group/weather/+/status", "weather/ST-1/observation", false},
+		{"$share/group", "group", false},
+	} {
+		if got := topicMatches(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+// fakeBroker answers the packets of an MQTT 5 client over an in-memory connection.
+type fakeBroker struct {
+	connack byte            // reason code of the CONNACK
+	refused map[string]byte // reason code of the SUBACK by topic filter
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		var reply *packets.ControlPacket
+		switch content := p.Content.(type) {
+		case *packets.Connect:
+			reply = packets.NewControlPacket(packets.CONNACK)
+			reply.Content.(*packets.Connack).ReasonCode = b.connack
+		case *packets.Subscribe:
+			reply = packets.NewControlPacket(packets.SUBACK)
+			suback := reply.Content.(*packets.Suback)
+			suback.PacketID = content.PacketID
+			for _, sub := range content.Subscriptions {
+				suback.Reasons = append(suback.Reasons, b.refused[sub.Topic])
+			}
+		case *packets.Unsubscribe:
+			reply = packets.NewControlPacket(packets.UNSUBACK)
+			unsuback := reply.Content.(*packets.Unsuback)
+			unsuback.PacketID = content.PacketID
+			unsuback.Reasons = make([]byte, len(content.Topics))
+		case *packets.Pingreq:
+			reply = packets.NewControlPacket(packets.PINGRESP)
+		case *packets.Disconnect:
+			return
+		default:
+			continue
+		}
+
+		if _, err := reply.WriteTo(conn); err != nil {
+			return
+		}
+	}
+}
+
+// newTestV5Client returns a client whose connections are made by dial.
+func newTestV5Client(events connectionEvents, dial func() (net.Conn, error)) *v5Client {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker("tcp://broker.invalid:1883")
+	opts.SetClientID("mqttweather-test")
+	opts.SetConnectTimeout(5 * time.Second)
+
+	c := newV5Client(opts, connectionProfile{Protocol: protocolV5}, events)
+	c.cfg.AttemptConnection = func(context.Context, autopaho.ClientConfig, *url.URL) (net.Conn, error) {
+		return dial()
+	}
+	return c
+}
+
+func (b *fakeBroker) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	go b.serve(server)
+	return client, nil
+}
+
+func waitToken(t *testing.T, tok mqtt.Token) error {
+	t.Helper()
+
+	if !tok.WaitTimeout(10 * time.Second) {
+		t.Fatal("operation did not complete")
+	}
+	return tok.Error()
+}
+
+func TestV5ClientConnectFailsOnFirstError(t *testing.T) {
+	errDial := errors.New("connection refused")
+
+	for name, dial := range map[string]func() (net.Conn, error){
+		"dial":    func() (net.Conn, error) { return nil, errDial },
+		"connack": (&fakeBroker{connack: 0x87}).dial,
+	} {
+		t.Run(name, func(t *testing.T) {
+			connected := false
+			c := newTestV5Client(connectionEvents{connected: func(mqttClient) { connected = true }}, dial)
+			defer c.Disconnect(0)
+
+			if err := waitToken(t, c.Connect()); err == nil {
+				t.Fatal("Connect succeeded")
+			}
+			if connected || c.IsConnected() {
+				t.Error("client reported as connected")
+			}
+		})
+	}
+}
+
+func TestV5ClientSubscribeReasonCodes(t *testing.T) {
+	broker := &fakeBroker{refused: map[string]byte{"private/#": 0x87}}
+	c := newTestV5Client(connectionEvents{}, broker.dial)
+	defer c.Disconnect(0)
+
+	if err := waitToken(t, c.Subscribe("weather/+/observation", 1, func(mqtt.Message) {})); err == nil {
+		t.Fatal("Subscribe succeeded before Connect")
+	}
+
+	if err := waitToken(t, c.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsConnected() || c.CleanSession() {
+		t.Error("unexpected session state after Connect")
+	}
+
+	if err := waitToken(t, c.Subscribe("weather/+/observation", 1, func(mqtt.Message) {})); err != nil {
+		t.Errorf("granted subscription failed: %v", err)
+	}
+
+	err := waitToken(t, c.Subscribe("private/#", 1, func(mqtt.Message) {}))
+	if err == nil {
+		t.Fatal("refused subscription succeeded")
+	}
+	if !strings.Contains(err.Error(), "private/#") || !strings.Contains(err.Error(), "0x87") {
+		t.Errorf("error %q does not report the topic and reason code", err)
+	}
+}
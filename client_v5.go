@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var _ mqttClient = (*v5Client)(nil)
+
+type v5Subscription struct {
+	qos     byte
+	handler mqttHandler
+}
+
+// v5Client adapts the paho MQTT 5 connection manager to the mqttClient interface.
+type v5Client struct {
+	events connectionEvents
+	cfg    autopaho.ClientConfig
+
+	lock      sync.RWMutex
+	cm        *autopaho.ConnectionManager
+	cancel    context.CancelFunc
+	connected bool
+	routes    map[string]*v5Subscription
+}
+
+func newV5Client(opts *mqtt.ClientOptions, profile connectionProfile, events connectionEvents) *v5Client {
+	c := &v5Client{events: events, routes: map[string]*v5Subscription{}}
+
+	c.cfg = autopaho.ClientConfig{
+		ServerUrls:                    opts.Servers,
+		TlsCfg:                        opts.TLSConfig,
+		KeepAlive:                     uint16(opts.KeepAlive),
+		CleanStartOnInitialConnection: profile.SessionExpiry == 0,
+		SessionExpiryInterval:         profile.SessionExpiry,
+		ConnectTimeout:                opts.ConnectTimeout,
+		ConnectUsername:               opts.Username,
+		ConnectPassword:               []byte(opts.Password),
+		ConnectPacketBuilder: func(cp *paho.Connect, _ *url.URL) (*paho.Connect, error) {
+			if len(profile.UserProperties) == 0 {
+				return cp, nil
+			}
+			if cp.Properties == nil {
+				cp.Properties = &paho.ConnectProperties{}
+			}
+			for k, v := range profile.UserProperties {
+				cp.Properties.User.Add(k, v)
+			}
+			return cp, nil
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: opts.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					return c.route(pr.Packet), nil
+				},
+			},
+			OnClientError: func(err error) {
+				c.lost(err)
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				c.lost(fmt.Errorf("disconnected by the broker (reason code 0x%02x)", d.ReasonCode))
+			},
+		},
+	}
+
+	return c
+}
+
+// setConnected returns whether the client was connected before.
+func (c *v5Client) setConnected(connected bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	was := c.connected
+	c.connected = connected
+	return was
+}
+
+// lost reports a dropped connection.
+func (c *v5Client) lost(err error) {
+	if c.setConnected(false) && c.events.lost != nil {
+		c.events.lost(c, err)
+	}
+}
+
+func (c *v5Client) manager() *autopaho.ConnectionManager {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.cm
+}
+
+func (c *v5Client) route(p *paho.Publish) bool {
+	c.lock.RLock()
+	var handlers []mqttHandler
+	for filter, sub := range c.routes {
+		if topicMatches(filter, p.Topic) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	c.lock.RUnlock()
+
+	for _, handler := range handlers {
+		handler(&v5Message{p})
+	}
+	return len(handlers) > 0
+}
+
+func (c *v5Client) IsConnected() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.connected
+}
+
+// CleanSession is false as the client subscribes again on its own when the
+// broker did not keep the session.
+func (c *v5Client) CleanSession() bool {
+	return false
+}
+
+// Connect fails on the first refused or failed attempt like the paho v3
+// client, automatic reconnection only starts once connected.
+func (c *v5Client) Connect() mqtt.Token {
+	t := newToken()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.lock.Lock()
+	c.cancel = cancel
+	c.lock.Unlock()
+
+	up := make(chan struct{})
+	var once sync.Once
+	failed := make(chan error, 1)
+
+	cfg := c.cfg
+	cfg.OnConnectionUp = func(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+		c.setConnected(true)
+		once.Do(func() { close(up) })
+
+		if !connack.SessionPresent {
+			c.resubscribe(ctx, cm)
+		}
+		if c.events.connected != nil {
+			c.events.connected(c)
+		}
+	}
+	cfg.OnConnectError = func(err error) {
+		select {
+		case <-up:
+			// autopaho keeps retrying, report it like paho v3 does
+			if c.events.reconnecting != nil {
+				c.events.reconnecting(c)
+			}
+			return
+		default:
+		}
+
+		select {
+		case failed <- err:
+		default:
+		}
+	}
+
+	go func() {
+		cm, err := autopaho.NewConnection(ctx, cfg)
+		if err != nil {
+			cancel()
+			t.complete(err)
+			return
+		}
+
+		c.lock.Lock()
+		c.cm = cm
+		c.lock.Unlock()
+
+		select {
+		case <-up:
+			t.complete(nil)
+		case err := <-failed:
+			cancel()
+			t.complete(err)
+		case <-ctx.Done():
+			t.complete(ctx.Err())
+		}
+	}()
+
+	return t
+}
+
+func (c *v5Client) resubscribe(ctx context.Context, cm *autopaho.ConnectionManager) {
+	c.lock.RLock()
+	sub := &paho.Subscribe{}
+	for filter, s := range c.routes {
+		sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{Topic: filter, QoS: s.qos})
+	}
+	c.lock.RUnlock()
+
+	if len(sub.Subscriptions) == 0 {
+		return
+	}
+	if _, err := cm.Subscribe(ctx, sub); err != nil {
+		fyne.LogError("Unable to restore MQTT 5 subscriptions", err)
+	}
+}
+
+func (c *v5Client) Disconnect(quiesce uint) {
+	c.lock.Lock()
+	cm, cancel := c.cm, c.cancel
+	c.cm, c.cancel, c.connected = nil, nil, false
+	c.lock.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	defer cancel()
+
+	if cm == nil {
+		return
+	}
+
+	ctx, done := context.WithTimeout(context.Background(), time.Duration(quiesce)*time.Millisecond+time.Second)
+	defer done()
+
+	cm.Disconnect(ctx)
+}
+
+func (c *v5Client) Subscribe(topic string, qos byte, handler mqttHandler) mqtt.Token {
+	t := newToken()
+
+	cm := c.manager()
+	if cm == nil {
+		t.complete(mqtt.ErrNotConnected)
+		return t
+	}
+
+	c.lock.Lock()
+	c.routes[topic] = &v5Subscription{qos: qos, handler: handler}
+	c.lock.Unlock()
+
+	go func() {
+		sub := &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: topic, QoS: qos}}}
+		suback, err := cm.Subscribe(context.Background(), sub)
+		if err != nil && suback != nil && len(suback.Reasons) > 0 && suback.Reasons[0] >= 0x80 {
+			err = fmt.Errorf("subscription to %s refused (reason code 0x%02x): %w", topic, suback.Reasons[0], err)
+		}
+		t.complete(err)
+	}()
+
+	return t
+}
+
+func (c *v5Client) Unsubscribe(topics ...string) mqtt.Token {
+	t := newToken()
+
+	c.lock.Lock()
+	for _, topic := range topics {
+		delete(c.routes, topic)
+	}
+	c.lock.Unlock()
+
+	cm := c.manager()
+	if cm == nil {
+		t.complete(mqtt.ErrNotConnected)
+		return t
+	}
+
+	go func() {
+		_, err := cm.Unsubscribe(context.Background(), &paho.Unsubscribe{Topics: topics})
+		t.complete(err)
+	}()
+
+	return t
+}
+
+// v5Message exposes a received MQTT 5 publish as a paho message.
+type v5Message struct {
+	*paho.Publish
+}
+
+func (m *v5Message) Qos() byte {
+	return m.QoS
+}
+
+func (m *v5Message) Retained() bool {
+	return m.Retain
+}
+
+func (m *v5Message) Topic() string {
+	return m.Publish.Topic
+}
+
+func (m *v5Message) MessageID() uint16 {
+	return m.PacketID
+}
+
+func (m *v5Message) Payload() []byte {
+	return m.Publish.Payload
+}
+
+func (m *v5Message) Ack() {}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+var compassCardinals = []string{"N", "E", "S", "W"}
+
+// windCompass draws a compass rose with an arrow in the direction the wind
+// blows from, a marker for the average bearing and the speed in its center.
+type windCompass struct {
+	widget.BaseWidget
+
+	Direction float64 // degrees, where the wind comes from
+	Average   float64 // degrees, average bearing
+	Speed     string
+	Detail    string
+
+	items    []binding.DataItem
+	listener binding.DataListener
+}
+
+func newWindCompass() *windCompass {
+	c := &windCompass{Speed: "-"}
+	c.ExtendBaseWidget(c)
+	return c
+}
+
+// Bind keeps the compass up to date with the wind observation.
+func (c *windCompass) Bind(direction, average binding.Float, speed, detail binding.String) {
+	c.Unbind()
+
+	c.items = []binding.DataItem{direction, average, speed, detail}
+	c.listener = binding.NewDataListener(func() {
+		c.Direction, _ = direction.Get()
+		c.Average, _ = average.Get()
+		c.Speed, _ = speed.Get()
+		c.Detail, _ = detail.Get()
+		c.Refresh()
+	})
+
+	for _, item := range c.items {
+		item.AddListener(c.listener)
+	}
+}
+
+func (c *windCompass) Unbind() {
+	for _, item := range c.items {
+		item.RemoveListener(c.listener)
+	}
+	c.items = nil
+}
+
+func (c *windCompass) CreateRenderer() fyne.WidgetRenderer {
+	r := &compassRenderer{compass: c,
+		ring:    canvas.NewCircle(color.Transparent),
+		arrow:   canvas.NewLine(theme.Color(theme.ColorNameForeground)),
+		left:    canvas.NewLine(theme.Color(theme.ColorNameForeground)),
+		right:   canvas.NewLine(theme.Color(theme.ColorNameForeground)),
+		average: canvas.NewCircle(theme.Color(theme.ColorNamePrimary)),
+		speed:   canvas.NewText("", theme.Color(theme.ColorNameForeground)),
+		detail:  canvas.NewText("", theme.Color(theme.ColorNameForeground)),
+	}
+
+	r.ring.StrokeWidth = 2
+	r.arrow.StrokeWidth = 2
+	r.left.StrokeWidth = 2
+	r.right.StrokeWidth = 2
+	r.speed.TextStyle.Bold = true
+	r.speed.Alignment = fyne.TextAlignCenter
+	r.detail.Alignment = fyne.TextAlignCenter
+	r.detail.TextSize = theme.CaptionTextSize()
+
+	for _, name := range compassCardinals {
+		text := canvas.NewText(name, theme.Color(theme.ColorNameForeground))
+		text.TextStyle.Bold = true
+		r.cardinals = append(r.cardinals, text)
+	}
+
+	r.Refresh()
+	return r
+}
+
+type compassRenderer struct {
+	compass *windCompass
+
+	ring               *canvas.Circle
+	cardinals          []*canvas.Text
+	arrow, left, right *canvas.Line
+	average            *canvas.Circle
+	speed, detail      *canvas.Text
+}
+
+func (r *compassRenderer) Destroy() {}
+
+func (r *compassRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(160, 160)
+}
+
+func (r *compassRenderer) Objects() []fyne.CanvasObject {
+	objects := []fyne.CanvasObject{r.ring, r.arrow, r.left, r.right, r.average, r.speed, r.detail}
+	for _, text := range r.cardinals {
+		objects = append(objects, text)
+	}
+	return objects
+}
+
+// point returns the position at angle degrees clockwise from north and distance from the center.
+func (r *compassRenderer) point(center fyne.Position, angle, distance float32) fyne.Position {
+	rad := float64(angle) * math.Pi / 180
+	return fyne.NewPos(center.X+distance*float32(math.Sin(rad)), center.Y-distance*float32(math.Cos(rad)))
+}
+
+func (r *compassRenderer) Layout(size fyne.Size) {
+	pad := theme.Padding()
+	label := r.cardinals[0].MinSize().Height
+	radius := fyne.Min(size.Width, size.Height)/2 - label - pad
+	center := fyne.NewPos(size.Width/2, size.Height/2)
+
+	r.ring.Move(fyne.NewPos(center.X-radius, center.Y-radius))
+	r.ring.Resize(fyne.NewSize(radius*2, radius*2))
+
+	for i, text := range r.cardinals {
+		pos := r.point(center, float32(i*90), radius+label/2+pad/2)
+		min := text.MinSize()
+		text.Move(fyne.NewPos(pos.X-min.Width/2, pos.Y-min.Height/2))
+		text.Resize(min)
+	}
+
+	// The arrow goes across the rose pointing where the wind blows to
+	direction := float32(r.compass.Direction)
+	tail := r.point(center, direction, radius-pad)
+	head := r.point(center, direction+180, radius-pad)
+	r.arrow.Position1, r.arrow.Position2 = tail, head
+	r.left.Position1, r.left.Position2 = head, r.point(head, direction+150, radius/5)
+	r.right.Position1, r.right.Position2 = head, r.point(head, direction+210, radius/5)
+
+	dot := radius / 8
+	average := r.point(center, float32(r.compass.Average), radius)
+	r.average.Move(fyne.NewPos(average.X-dot/2, average.Y-dot/2))
+	r.average.Resize(fyne.NewSize(dot, dot))
+
+	speed := r.speed.MinSize()
+	detail := r.detail.MinSize()
+	r.speed.Move(fyne.NewPos(center.X-speed.Width/2, center.Y-speed.Height))
+	r.speed.Resize(speed)
+	r.detail.Move(fyne.NewPos(center.X-detail.Width/2, center.Y))
+	r.detail.Resize(detail)
+}
+
+func (r *compassRenderer) Refresh() {
+	foreground := theme.Color(theme.ColorNameForeground)
+
+	r.ring.StrokeColor = foreground
+	r.arrow.StrokeColor = foreground
+	r.left.StrokeColor = foreground
+	r.right.StrokeColor = foreground
+	r.average.FillColor = theme.Color(theme.ColorNamePrimary)
+	for _, text := range r.cardinals {
+		text.Color = foreground
+	}
+
+	r.speed.Text = r.compass.Speed
+	r.speed.Color = foreground
+	r.detail.Text = r.compass.Detail
+	r.detail.Color = foreground
+
+	r.Layout(r.compass.Size())
+	for _, o := range r.Objects() {
+		o.Refresh()
+	}
+}
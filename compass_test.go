@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+func TestWindCompassBearings(t *testing.T) {
+	test.NewTempApp(t)
+
+	for _, tt := range []struct {
+		name               string
+		direction, average float64
+	}{
+		{"north", 0, 0},
+		{"east", 90, 80},
+		{"south_west", 225, 200},
+		{"north_north_west", 337.5, 350},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newWindCompass()
+			c.Direction, c.Average = tt.direction, tt.average
+			c.Speed, c.Detail = "12.3 km/h", "gust 20.1, lull 4.2"
+
+			w := test.NewTempWindow(t, c)
+			w.Resize(fyne.NewSize(200, 200))
+			c.Refresh()
+
+			test.AssertImageMatches(t, "compass/"+tt.name+".png", w.Canvas().Capture())
+		})
+	}
+}
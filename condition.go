@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+type weatherCondition int
+
+const (
+	conditionSunny weatherCondition = iota
+	conditionNight
+	conditionPartlyCloudy
+	conditionCloudy
+	conditionWindy
+	conditionCloudyWindy
+	conditionRaining
+	conditionPouring
+	conditionSnow
+	conditionLightning
+	conditionPartlyCloudyLightning
+	conditionRainingLightning
+)
+
+// conditionObservation holds the observation fields the current condition is derived from.
+type conditionObservation struct {
+	PrecipitationType string
+	RainIntensity     string
+	AirTemperature    float64
+	LightningCount1h  float64
+	LightningDistance float64 // km, 0 when unknown
+	SolarRadiation    float64
+	Illuminance       float64
+	WindSpeed         float64
+	Beaufort          float64
+	Time              time.Time
+}
+
+// deriveCondition guesses the current weather from a station observation,
+// precipitation first, then thunderstorms, wind and finally the sky brightness.
+func deriveCondition(o conditionObservation) weatherCondition {
+	raining := isPresent(o.PrecipitationType) || isPresent(o.RainIntensity)
+	lightning := o.LightningCount1h > 0 && o.LightningDistance <= lightningNearby
+
+	if raining {
+		switch {
+		case lightning:
+			return conditionRainingLightning
+		case strings.Contains(strings.ToLower(o.PrecipitationType), "snow"), o.AirTemperature <= 0:
+			return conditionSnow
+		case isHeavyRain(o.RainIntensity):
+			return conditionPouring
+		}
+		return conditionRaining
+	}
+
+	sky := deriveSky(o)
+	windy := o.Beaufort >= 6 || o.WindSpeed >= 39
+
+	switch {
+	case lightning && (sky == conditionSunny || sky == conditionPartlyCloudy):
+		return conditionPartlyCloudyLightning
+	case lightning:
+		return conditionLightning
+	case windy && sky == conditionCloudy:
+		return conditionCloudyWindy
+	case windy && sky != conditionNight:
+		return conditionWindy
+	}
+	return sky
+}
+
+// deriveSky estimates the cloud cover from the brightness measured by the station.
+func deriveSky(o conditionObservation) weatherCondition {
+	if o.Illuminance < 50 && o.SolarRadiation < 5 {
+		// A dark sky during the day means heavy clouds
+		if hour := o.Time.Hour(); hour >= 7 && hour < 19 {
+			return conditionCloudy
+		}
+		return conditionNight
+	}
+
+	switch {
+	case o.Illuminance >= 20000 || o.SolarRadiation >= 400:
+		return conditionSunny
+	case o.Illuminance >= 5000 || o.SolarRadiation >= 100:
+		return conditionPartlyCloudy
+	}
+	return conditionCloudy
+}
+
+func isPresent(s string) bool {
+	return s != "" && !strings.EqualFold(s, "none")
+}
+
+func isHeavyRain(intensity string) bool {
+	switch strings.ToLower(intensity) {
+	case "heavy", "very heavy", "extreme":
+		return true
+	}
+	return false
+}
+
+func (c weatherCondition) icon() fyne.Resource {
+	switch c {
+	case conditionNight:
+		return weatherNight
+	case conditionPartlyCloudy:
+		return weatherPartlyCloudy
+	case conditionCloudy:
+		return weatherCloudy
+	case conditionWindy:
+		return weatherWindy
+	case conditionCloudyWindy:
+		return weatherCloudyWindy
+	case conditionRaining:
+		return weatherCloudyRaining
+	case conditionPouring:
+		return weatherCloudyPouring
+	case conditionSnow:
+		return weatherSnowflake
+	case conditionLightning:
+		return weatherCloudyLightning
+	case conditionPartlyCloudyLightning:
+		return weatherPartlyCloudyLightning
+	case conditionRainingLightning:
+		return weatherCloudyRainingLightning
+	}
+	return weatherSunny
+}
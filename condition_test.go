@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeriveCondition(t *testing.T) {
+	noon := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	midnight := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+
+	for name, tt := range map[string]struct {
+		o    conditionObservation
+		want weatherCondition
+	}{
+		"sunny":          {conditionObservation{Illuminance: 50000, AirTemperature: 20, Time: noon}, conditionSunny},
+		"night":          {conditionObservation{Time: midnight}, conditionNight},
+		"dark day":       {conditionObservation{Time: noon}, conditionCloudy},
+		"windy":          {conditionObservation{Illuminance: 50000, Beaufort: 6, Time: noon}, conditionWindy},
+		"rain":           {conditionObservation{PrecipitationType: "Rain", AirTemperature: 5, Time: noon}, conditionRaining},
+		"rain none":      {conditionObservation{PrecipitationType: "None", RainIntensity: "none", Illuminance: 50000, Time: noon}, conditionSunny},
+		"pouring":        {conditionObservation{PrecipitationType: "Rain", RainIntensity: "Heavy", AirTemperature: 5, Time: noon}, conditionPouring},
+		"snow type":      {conditionObservation{PrecipitationType: "Snow", AirTemperature: 2, Time: noon}, conditionSnow},
+		"freezing rain":  {conditionObservation{PrecipitationType: "Rain", AirTemperature: 0, Time: noon}, conditionSnow},
+		"freezing heavy": {conditionObservation{RainIntensity: "Heavy", AirTemperature: -3, Time: noon}, conditionSnow},
+		"cold dry":       {conditionObservation{AirTemperature: -10, Illuminance: 50000, Time: noon}, conditionSunny},
+		"storm nearby":   {conditionObservation{LightningCount1h: 3, LightningDistance: 8, Time: noon}, conditionLightning},
+		"storm sunny":    {conditionObservation{LightningCount1h: 3, LightningDistance: 8, Illuminance: 50000, Time: noon}, conditionPartlyCloudyLightning},
+		"storm far away": {conditionObservation{LightningCount1h: 3, LightningDistance: 35, Illuminance: 50000, Time: noon}, conditionSunny},
+		"storm unknown":  {conditionObservation{LightningCount1h: 3, Illuminance: 50000, Time: noon}, conditionPartlyCloudyLightning},
+		"storm at limit": {conditionObservation{LightningCount1h: 1, LightningDistance: lightningNearby, Time: noon}, conditionLightning},
+		"storm raining":  {conditionObservation{PrecipitationType: "Rain", AirTemperature: -1, LightningCount1h: 1, LightningDistance: 5, Time: noon}, conditionRainingLightning},
+		"far rain storm": {conditionObservation{PrecipitationType: "Rain", AirTemperature: 10, LightningCount1h: 1, LightningDistance: 30, Time: noon}, conditionRaining},
+	} {
+		if got := deriveCondition(tt.o); got != tt.want {
+			t.Errorf("%s: deriveCondition() = %d, want %d", name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// connectionState is the step the MQTT session is at.
+type connectionState int
+
+const (
+	stateIdle connectionState = iota
+	stateConnecting
+	stateDiscovering
+	stateWaitingForData
+	stateLive
+	stateReconnecting
+	stateStopping
+)
+
+func (s connectionState) String() string {
+	switch s {
+	case stateConnecting:
+		return "connecting"
+	case stateDiscovering:
+		return "discovering"
+	case stateWaitingForData:
+		return "waiting for data"
+	case stateLive:
+		return "live"
+	case stateReconnecting:
+		return "reconnecting"
+	case stateStopping:
+		return "stopping"
+	}
+	return "idle"
+}
+
+// settingUp reports whether the session is still being established, when
+// cancelling the standby dialog aborts it.
+func (s connectionState) settingUp() bool {
+	return s == stateConnecting || s == stateDiscovering || s == stateWaitingForData
+}
+
+// brokerConnection is the MQTT session shared by all the station cards. Its
+// state is only changed through transitions, which are safe to call from the
+// UI, the dialogs and the paho callbacks.
+type brokerConnection struct {
+	lock      sync.Mutex
+	state     connectionState
+	cancel    context.CancelCauseFunc
+	client    mqttClient
+	profile   connectionProfile
+	discovery []string
+}
+
+// start moves an idle connection to connecting and returns the context
+// cancelled when the session is aborted, fails or is stopped.
+func (conn *brokerConnection) start(client mqttClient, profile connectionProfile) (context.Context, bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.state != stateIdle {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	conn.state, conn.cancel = stateConnecting, cancel
+	conn.client, conn.profile = client, profile
+	return ctx, true
+}
+
+// transition changes the state if it still is from, and reports whether it did.
+func (conn *brokerConnection) transition(from, to connectionState) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.state != from {
+		return false
+	}
+	conn.state = to
+	return true
+}
+
+func (conn *brokerConnection) current() connectionState {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	return conn.state
+}
+
+// abort cancels the session while it is being set up, it has no effect once live.
+func (conn *brokerConnection) abort() {
+	conn.fail(context.Canceled)
+}
+
+// fail cancels the session with err while it is being set up, and reports
+// whether it did. The setup step waiting then stops the session.
+func (conn *brokerConnection) fail(err error) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if !conn.state.settingUp() {
+		return false
+	}
+	conn.cancel(err)
+	return true
+}
+
+// stop moves any active session to stopping and returns its client, or nil
+// when there is nothing to stop or another stop is in progress.
+func (conn *brokerConnection) stop() mqttClient {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.state == stateIdle || conn.state == stateStopping {
+		return nil
+	}
+
+	conn.state = stateStopping
+	conn.cancel(context.Canceled)
+	return conn.client
+}
+
+// stopped ends a stop, making the connection idle again.
+func (conn *brokerConnection) stopped() {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.state, conn.cancel = stateIdle, nil
+	conn.client, conn.discovery = nil, nil
+}
+
+// session returns the client of the session, nil when idle or stopping.
+func (conn *brokerConnection) session() mqttClient {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.state == stateIdle || conn.state == stateStopping {
+		return nil
+	}
+	return conn.client
+}
+
+func (conn *brokerConnection) setDiscovery(topics ...string) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.discovery = topics
+}
+
+// takeDiscovery returns the discovery subscriptions still active, only once.
+func (conn *brokerConnection) takeDiscovery() []string {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	topics := conn.discovery
+	conn.discovery = nil
+	return topics
+}
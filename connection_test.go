@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/test"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeClient is an mqttClient connecting only when the test completes its
+// connect token, so the session stays at the step the test moved it to.
+type fakeClient struct {
+	connect *token
+
+	lock         sync.Mutex
+	connecting   bool
+	handlers     map[string]mqttHandler
+	disconnected int
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{connect: newToken(), handlers: map[string]mqttHandler{}}
+}
+
+func (c *fakeClient) Connect() mqtt.Token {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.connecting = true
+	return c.connect
+}
+
+func (c *fakeClient) Disconnect(uint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.disconnected++
+}
+
+func (c *fakeClient) IsConnected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.disconnected == 0
+}
+
+func (c *fakeClient) Subscribe(topic string, _ byte, handler mqttHandler) mqtt.Token {
+	c.lock.Lock()
+	c.handlers[topic] = handler
+	c.lock.Unlock()
+
+	t := newToken()
+	t.complete(nil)
+	return t
+}
+
+func (c *fakeClient) Unsubscribe(topics ...string) mqtt.Token {
+	c.lock.Lock()
+	for _, topic := range topics {
+		delete(c.handlers, topic)
+	}
+	c.lock.Unlock()
+
+	t := newToken()
+	t.complete(nil)
+	return t
+}
+
+func (c *fakeClient) CleanSession() bool {
+	return true
+}
+
+func (c *fakeClient) subscriptions() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.handlers)
+}
+
+func (c *fakeClient) disconnects() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.disconnected
+}
+
+// publish delivers a message to the handlers subscribed to its topic.
+func (c *fakeClient) publish(topic, payload string) {
+	c.lock.Lock()
+	handlers := []mqttHandler{}
+	for filter, handler := range c.handlers {
+		if topicMatches(filter, topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.lock.Unlock()
+
+	for _, handler := range handlers {
+		handler(&fakeMessage{topic: topic, payload: []byte(payload)})
+	}
+}
+
+func (c *fakeClient) connectCalled() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.connecting
+}
+
+func (c *fakeClient) subscribed(topic string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.handlers[topic]
+	return ok
+}
+
+// fakeMessage is an mqtt.Message delivered by the fake client.
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 1 }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              {}
+
+// waitFor fails the test when the condition does not hold within 5 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+const (
+	setupConnecting = iota
+	setupDiscovering
+	setupChoosing
+	setupWaitingForData
+	setupLive
+)
+
+// TestConnectionSetupStop goes through the setup of connectionDialogShow up
+// to each step, then cancels the standby dialog, disconnects or loses the
+// connection.
+func TestConnectionSetupStop(t *testing.T) {
+	window := discoveryWindow
+	discoveryWindow = 10 * time.Millisecond
+	defer func() { discoveryWindow = window }()
+
+	for _, step := range []int{setupConnecting, setupDiscovering, setupChoosing, setupWaitingForData, setupLive} {
+		for _, action := range []string{"cancel", "disconnect", "lost"} {
+			name := []string{"connecting", "discovering", "choosing", "waiting for data", "live"}[step] + " " + action
+
+			t.Run(name, func(t *testing.T) {
+				app := newTestApplication(t)
+				client := newFakeClient()
+				profile := connectionProfile{Name: "test", Broker: "tcp://broker.local:1883/"}
+				if step >= setupWaitingForData {
+					profile.Stations = []string{"1234"}
+				}
+				topics, err := profile.topics()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ctx, ok := app.conn.start(client, profile)
+				if !ok {
+					t.Fatal("unable to start the connection")
+				}
+				app.status.follow(client)
+				d, standbyAction := app.standbyDialogShow(profile.Broker)
+				setup := make(chan struct{})
+				go func() {
+					app.asynchronousConnect(ctx, d, standbyAction, client, profile)
+					close(setup)
+				}()
+
+				// The setup takes over the standby dialog before connecting
+				waitFor(t, "the connection", client.connectCalled)
+				if step > setupConnecting {
+					client.connect.complete(nil)
+				}
+				switch step {
+				case setupDiscovering, setupChoosing:
+					waitFor(t, "the discovery subscription", func() bool {
+						return app.conn.current() == stateDiscovering && client.subscribed(topics.discovery)
+					})
+				case setupWaitingForData, setupLive:
+					waitFor(t, "the station subscriptions", func() bool {
+						return app.conn.current() == stateWaitingForData && client.subscriptions() == 2
+					})
+				}
+				if step == setupChoosing {
+					for _, serial := range []string{"1234", "5678"} {
+						client.publish(strings.Replace(topics.discovery, "+", "weatherflow2mqtt_ST-"+serial, 1), `{"attribution":"Tempest"}`)
+					}
+					waitFor(t, "the station chooser", func() bool {
+						return len(app.window.Canvas().Overlays().List()) == 2
+					})
+				}
+				if step == setupLive {
+					client.publish(topics.observationTopic("1234"), `{"air_temperature":21.5}`)
+					waitFor(t, "the first observation", func() bool { return app.conn.current() == stateLive })
+				}
+
+				switch action {
+				case "cancel":
+					d.Hide()
+				case "disconnect":
+					test.Tap(app.action)
+				case "lost":
+					app.connectionEvents().lost(client, errors.New("EOF"))
+				}
+
+				if step == setupLive && action != "disconnect" {
+					// Cancelling or losing a live session keeps it
+					time.Sleep(20 * time.Millisecond)
+					want := stateLive
+					if action == "lost" {
+						want = stateReconnecting
+					}
+					if app.conn.current() != want || client.disconnects() != 0 {
+						t.Fatalf("live session is %s after %s, want %s", app.conn.current(), action, want)
+					}
+					test.Tap(app.action)
+				}
+
+				waitFor(t, "the session to stop", func() bool { return app.conn.current() == stateIdle })
+				select {
+				case <-setup:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the setup did not return")
+				}
+
+				if client.disconnects() != 1 {
+					t.Errorf("client disconnected %d times, want once", client.disconnects())
+				}
+				if client.subscriptions() != 0 {
+					t.Errorf("%d subscriptions left after stopping", client.subscriptions())
+				}
+				if app.action.Text != "Connect" || !app.addStation.Disabled() || app.status.label.Text == "Connected" {
+					t.Errorf("dashboard still connected: %q, status %q", app.action.Text, app.status.label.Text)
+				}
+				for _, card := range app.cardList() {
+					if card.enabled {
+						t.Error("card still enabled after stopping")
+					}
+				}
+
+				// Stopping again does nothing, and a new session can start
+				app.stopMqtt(nil)
+				if client.disconnects() != 1 {
+					t.Errorf("client disconnected %d times after stopping again", client.disconnects())
+				}
+				if _, ok := app.conn.start(newFakeClient(), profile); !ok {
+					t.Error("unable to start again after stopping")
+				}
+			})
+		}
+	}
+}
+
+func TestConnectionStopOnce(t *testing.T) {
+	for _, state := range []connectionState{stateConnecting, stateDiscovering, stateWaitingForData, stateLive, stateReconnecting} {
+		conn := &brokerConnection{}
+		client := newFakeClient()
+		ctx, _ := conn.start(client, connectionProfile{Name: "test"})
+		path := map[connectionState][]connectionState{
+			stateDiscovering:    {stateDiscovering},
+			stateWaitingForData: {stateWaitingForData},
+			stateLive:           {stateWaitingForData, stateLive},
+			stateReconnecting:   {stateWaitingForData, stateLive, stateReconnecting},
+		}[state]
+		from := stateConnecting
+		for _, to := range path {
+			if !conn.transition(from, to) {
+				t.Fatalf("transition from %s to %s refused", from, to)
+			}
+			from = to
+		}
+
+		// Cancel and stop from several places at once
+		stopped := make(chan mqttClient, 3)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				conn.abort()
+			}()
+			go func() {
+				defer wg.Done()
+				stopped <- conn.stop()
+			}()
+		}
+		wg.Wait()
+		close(stopped)
+
+		count := 0
+		for c := range stopped {
+			if c != nil {
+				count++
+			}
+		}
+		if count != 1 || ctx.Err() == nil || conn.session() != nil {
+			t.Errorf("%s: stopped %d times, want once", state, count)
+		}
+		conn.stopped()
+		if _, ok := conn.start(newFakeClient(), connectionProfile{}); !ok {
+			t.Errorf("%s: unable to start again after stopping", state)
+		}
+	}
+}
+
+func TestConnectionStopWhenIdle(t *testing.T) {
+	conn := &brokerConnection{}
+	conn.abort()
+	if conn.stop() != nil || conn.current() != stateIdle {
+		t.Error("stopping an idle connection had an effect")
+	}
+
+	ctx, _ := conn.start(newFakeClient(), connectionProfile{})
+	conn.abort()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("aborted context error = %v", ctx.Err())
+	}
+	if conn.current() != stateConnecting {
+		t.Error("abort changed the state, stopping is left to the setup")
+	}
+}
+
+func TestConnectionLostWhileSettingUp(t *testing.T) {
+	lost := errors.New("EOF")
+
+	for _, state := range []connectionState{stateConnecting, stateDiscovering, stateWaitingForData, stateLive} {
+		t.Run(state.String(), func(t *testing.T) {
+			app := &application{app: test.NewTempApp(t), conn: &brokerConnection{}, status: newConnectionStatus()}
+			events := app.connectionEvents()
+
+			client := newFakeClient()
+			ctx, _ := app.conn.start(client, connectionProfile{Name: "test"})
+			app.status.follow(client)
+			events.connected(client)
+
+			from := stateConnecting
+			for _, to := range map[connectionState][]connectionState{
+				stateDiscovering:    {stateDiscovering},
+				stateWaitingForData: {stateWaitingForData},
+				stateLive:           {stateWaitingForData, stateLive},
+			}[state] {
+				app.conn.transition(from, to)
+				from = to
+			}
+
+			// The setup step waiting for the broker is told why it stopped
+			setup := make(chan error)
+			go func() { setup <- waitStep(ctx, newToken()) }()
+
+			events.lost(client, lost)
+
+			if state == stateLive {
+				if app.conn.current() != stateReconnecting || ctx.Err() != nil {
+					t.Errorf("live session lost is %s, want reconnecting", app.conn.current())
+				}
+				events.connected(client)
+				if app.conn.current() != stateLive {
+					t.Errorf("reconnected session is %s, want live", app.conn.current())
+				}
+				app.conn.stop()
+				if err := <-setup; !errors.Is(err, context.Canceled) {
+					t.Errorf("stopped setup step error = %v", err)
+				}
+				return
+			}
+
+			select {
+			case err := <-setup:
+				if !errors.Is(err, lost) || errors.Is(err, context.Canceled) {
+					t.Errorf("setup step error = %v, want the connection loss", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the setup step kept waiting after the connection was lost")
+			}
+			if app.conn.current() != state {
+				t.Errorf("state changed to %s, stopping is left to the setup", app.conn.current())
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+)
+
+func (app *application) makeDashboard() fyne.CanvasObject {
+	app.grid = container.NewGridWrap(fyne.NewSize(420, 480))
+
+	bar := container.NewHBox(app.addStation, app.settings, app.sections, app.export, app.alerts, layout.NewSpacer(), app.action)
+	return container.NewBorder(nil, container.NewVBox(app.status.label, bar), nil, nil,
+		container.NewVScroll(app.grid))
+}
+
+// restoreStations shows the cards of the last used profile until we are connected.
+func (app *application) restoreStations() {
+	stations := app.conn.profile.Stations
+	if len(stations) == 0 {
+		stations = []string{""}
+	}
+
+	app.showStations(stations)
+}
+
+// showStations makes the dashboard display one card per serial, keeping the
+// cards already displayed for those serials and releasing the others.
+func (app *application) showStations(serials []string) {
+	existing := map[string]*weatherCard{}
+	for _, card := range app.cardList() {
+		existing[card.serial] = card
+	}
+
+	cards := make([]*weatherCard, 0, len(serials))
+	for _, serial := range serials {
+		card, ok := existing[serial]
+		if !ok {
+			card = app.newWeatherCard(serial)
+		}
+		cards = append(cards, card)
+	}
+
+	app.cardsLock.Lock()
+	app.cards = cards
+	app.cardsLock.Unlock()
+
+	kept := map[*weatherCard]bool{}
+	for _, card := range cards {
+		kept[card] = true
+	}
+	for _, card := range existing {
+		if !kept[card] {
+			card.release()
+		}
+	}
+
+	app.refreshDashboard()
+}
+
+// cardList returns the displayed cards, the slice can be ranged over from any goroutine.
+func (app *application) cardList() []*weatherCard {
+	app.cardsLock.Lock()
+	defer app.cardsLock.Unlock()
+
+	return append([]*weatherCard(nil), app.cards...)
+}
+
+func (app *application) refreshDashboard() {
+	cards := app.cardList()
+	objects := make([]fyne.CanvasObject, len(cards))
+	for i, card := range cards {
+		objects[i] = card.content
+	}
+
+	app.grid.Objects = objects
+	app.grid.Refresh()
+}
+
+// saveStations remembers the displayed stations for the next connection with the same profile.
+func (app *application) saveStations() {
+	serials := []string{}
+	for _, card := range app.cardList() {
+		if card.serial != "" {
+			serials = append(serials, card.serial)
+		}
+	}
+
+	app.conn.profile.Stations = serials
+	app.rememberStations(app.conn.profile.Name, serials)
+}
+
+func (app *application) removeStation(card *weatherCard) {
+	card.release()
+
+	app.cardsLock.Lock()
+	for i, c := range app.cards {
+		if c == card {
+			app.cards = append(app.cards[:i], app.cards[i+1:]...)
+			break
+		}
+	}
+	app.cardsLock.Unlock()
+
+	app.refreshDashboard()
+	app.saveStations()
+}
+
+// addStationShow looks for stations not displayed yet on the connected broker
+// and adds a card for the one selected by the user.
+func (app *application) addStationShow() {
+	client := app.conn.session()
+	if client == nil {
+		return
+	}
+	topics, err := app.conn.profile.topics()
+	if err != nil {
+		dialog.ShowError(err, app.window)
+		return
+	}
+	configTopic := haConfigTopic(app.conn.profile.topicPrefix())
+
+	action := widget.NewLabel("Looking for weather stations.")
+	infinite := widget.NewProgressBarInfinite()
+	infinite.Start()
+
+	cancelled := newToken()
+	d := dialog.NewCustom("Add a weather station", "Cancel", container.NewVBox(container.NewCenter(action), infinite), app.window)
+	d.SetOnClosed(func() { cancelled.complete(nil) })
+	d.Show()
+
+	discovery := newStationDiscovery(topics, func(count int) {
+		action.SetText(fmt.Sprintf("Found %d weather station(s), looking for more.", count))
+	})
+
+	go func() {
+		for topic, handler := range map[string]mqttHandler{topics.discovery: discovery.handle, configTopic: discovery.handleConfig} {
+			token := client.Subscribe(topic, app.conn.profile.QoS, handler)
+			if token.Wait() && token.Error() != nil {
+				client.Unsubscribe(topics.discovery, configTopic)
+				d.Hide()
+				dialog.ShowError(token.Error(), app.window)
+				return
+			}
+		}
+
+		select {
+		case <-cancelled.Done():
+		case <-time.After(discoveryWindow):
+		}
+		client.Unsubscribe(topics.discovery, configTopic)
+
+		select {
+		case <-cancelled.Done():
+			return
+		default:
+			d.Hide()
+		}
+
+		displayed := map[string]bool{}
+		for _, card := range app.cardList() {
+			displayed[card.serial] = true
+		}
+
+		stations := []discoveredStation{}
+		for _, s := range discovery.list() {
+			if !displayed[s.serial] {
+				stations = append(stations, s)
+			}
+		}
+		if len(stations) == 0 {
+			dialog.ShowInformation("Add a weather station", "No other weather station found on this broker.", app.window)
+			return
+		}
+
+		choice := app.stationChooserShow(stations, "")
+		choice.Wait()
+		if choice.serial == "" {
+			return
+		}
+		for _, s := range stations {
+			if s.serial == choice.serial {
+				app.rememberDevice(s)
+			}
+		}
+
+		card := app.newWeatherCard(choice.serial)
+		if err := app.bindCard(card, func() {}); err != nil {
+			dialog.ShowError(err, app.window)
+			return
+		}
+
+		app.cardsLock.Lock()
+		app.cards = append(app.cards, card)
+		app.cardsLock.Unlock()
+
+		app.refreshDashboard()
+		app.saveStations()
+	}()
+}
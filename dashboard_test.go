@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"fyne.io/fyne/v2/test"
+	"fyne.io/fyne/v2/widget"
+)
+
+// newTestApplication builds the dashboard like main does, with secrets in
+// memory. Fyne 2.5 widgets are updated from the binding goroutine while the
+// dashboard lays them out, so the tests using it are skipped by the race detector.
+func newTestApplication(t *testing.T) *application {
+	if raceDetector {
+		t.Skip("the dashboard widgets are updated from the binding goroutine")
+	}
+
+	a := test.NewTempApp(t)
+	w := test.NewTempWindow(t, widget.NewLabel(""))
+
+	app := newApplication(a, w, newMemoryStore(), filepath.Join(t.TempDir(), "observations"))
+	w.SetContent(app.makeDashboard())
+	return app
+}
+
+func TestShowStationsReleasesReplacedCards(t *testing.T) {
+	app := newTestApplication(t)
+	app.restoreStations()
+
+	client := newFakeClient()
+	if _, ok := app.conn.start(client, connectionProfile{Name: "test"}); !ok {
+		t.Fatal("unable to start the connection")
+	}
+
+	app.showStations([]string{"ST-1", "ST-2"})
+	for _, card := range app.cardList() {
+		if err := app.bindCard(card, func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept := app.cardList()[1]
+	if client.subscriptions() != 4 {
+		t.Fatalf("%d subscriptions for two stations, want 4", client.subscriptions())
+	}
+
+	app.showStations([]string{"ST-2", "ST-3"})
+	cards := app.cardList()
+	if len(cards) != 2 || cards[0] != kept || cards[1].serial != "ST-3" {
+		t.Fatalf("unexpected cards after switching stations: %v", cards)
+	}
+	if client.subscriptions() != 2 {
+		t.Errorf("%d subscriptions left, want the 2 of the kept station", client.subscriptions())
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// discoveryWindow is how long we keep listening for other stations after the first one showed up.
+var discoveryWindow = 5 * time.Second
+
+type discoveredStation struct {
+	serial      string
+	attribution string
+	lastSeen    time.Time
+
+	device *haDevice // set for the devices found through Home Assistant discovery
+}
+
+func (s discoveredStation) String() string {
+	text := "ST-" + s.serial
+	if s.device != nil {
+		text = s.device.name + fmt.Sprintf(" (%d sensors)", len(s.device.sensors))
+	}
+	if s.attribution != "" {
+		text += " (" + s.attribution + ")"
+	}
+	return text + fmt.Sprintf(", seen %s ago", time.Since(s.lastSeen).Truncate(time.Second))
+}
+
+// stationDiscovery collects the Tempest stations announcing themselves on the status topic.
+type stationDiscovery struct {
+	match *regexp.Regexp
+	first *token
+
+	lock     sync.Mutex
+	stations map[string]*discoveredStation
+	found    func(count int)
+}
+
+func newStationDiscovery(topics stationTopics, found func(count int)) *stationDiscovery {
+	return &stationDiscovery{
+		match:    topics.serial,
+		first:    newToken(),
+		stations: map[string]*discoveredStation{},
+		found:    found,
+	}
+}
+
+func (sd *stationDiscovery) handle(msg mqtt.Message) {
+	r := sd.match.FindStringSubmatch(msg.Topic())
+	if len(r) < 2 || r[1] == "" {
+		return
+	}
+
+	var attributes struct {
+		Attribution string `json:"attribution"`
+	}
+	json.Unmarshal(msg.Payload(), &attributes)
+
+	sd.lock.Lock()
+	s, ok := sd.stations[r[1]]
+	if !ok {
+		s = &discoveredStation{serial: r[1]}
+		sd.stations[r[1]] = s
+	}
+	s.attribution = attributes.Attribution
+	s.lastSeen = time.Now()
+	count := len(sd.stations)
+	sd.lock.Unlock()
+
+	if !ok {
+		sd.found(count)
+	}
+	sd.first.complete(nil)
+}
+
+// list returns the stations found so far ordered by serial number.
+func (sd *stationDiscovery) list() []discoveredStation {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	stations := make([]discoveredStation, 0, len(sd.stations))
+	for _, s := range sd.stations {
+		station := *s
+		if s.device != nil {
+			// Copy the device so it can be read while more sensors are announced
+			device := *s.device
+			device.sensors = map[string]haSensorConfig{}
+			for topic, c := range s.device.sensors {
+				device.sensors[topic] = c
+			}
+			station.device = &device
+		}
+		stations = append(stations, station)
+	}
+	sort.Slice(stations, func(i, j int) bool {
+		return stations[i].serial < stations[j].serial
+	})
+
+	return stations
+}
+
+// stationChoice completes once the user picked a station, serial is empty if the chooser was dismissed.
+type stationChoice struct {
+	*token
+	serial string
+	dialog dialog.Dialog
+}
+
+func (app *application) stationChooserShow(stations []discoveredStation, selected string) *stationChoice {
+	choice := &stationChoice{token: newToken()}
+
+	options := make([]string, len(stations))
+	for i, s := range stations {
+		options[i] = s.String()
+	}
+
+	radio := widget.NewRadioGroup(options, nil)
+	radio.Required = true
+	radio.SetSelected(options[0])
+	for i, s := range stations {
+		if s.serial == selected {
+			radio.SetSelected(options[i])
+		}
+	}
+
+	choice.dialog = dialog.NewCustomConfirm("Select a weather station", "Show", "Cancel", radio, func(ok bool) {
+		if ok {
+			for i, option := range options {
+				if option == radio.Selected {
+					choice.serial = stations[i].serial
+				}
+			}
+		}
+		choice.complete(nil)
+	}, app.window)
+	choice.dialog.Resize(fyne.NewSize(400, 100))
+	choice.dialog.Show()
+
+	return choice
+}
+
+// rememberStations saves the stations displayed for a connection profile.
+func (app *application) rememberStations(profile string, serials []string) {
+	profiles := app.loadProfiles()
+	for i := range profiles {
+		if profiles[i].Name == profile {
+			profiles[i].Stations = serials
+		}
+	}
+	app.saveProfiles(profiles)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	exportCSV        = "CSV"
+	exportJSONLines  = "JSON Lines"
+	exportTimeLayout = "2006-01-02 15:04"
+)
+
+// exportColumn is one CSV column, converted to the unit chosen for the export.
+type exportColumn struct {
+	field   observationField
+	unit    string
+	convert func(float64) float64 // nil keeps the value as published
+}
+
+func (c exportColumn) header() string {
+	if c.unit == "" {
+		return c.field.key
+	}
+	return c.field.key + " (" + c.unit + ")"
+}
+
+func (c exportColumn) value(fields map[string]interface{}) string {
+	switch v := fields[c.field.key].(type) {
+	case float64:
+		if c.convert != nil {
+			v = c.convert(v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exportObservations writes the records as CSV with the given columns, or as
+// JSON Lines holding the full payloads.
+func exportObservations(w io.Writer, records []observationRecord, format string, columns []exportColumn) error {
+	switch format {
+	case exportJSONLines:
+		encoder := json.NewEncoder(w)
+		for _, r := range records {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case exportCSV:
+		out := csv.NewWriter(w)
+
+		row := []string{"time"}
+		for _, c := range columns {
+			row = append(row, c.header())
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			var fields map[string]interface{}
+			if err := json.Unmarshal(r.Payload, &fields); err != nil {
+				continue
+			}
+
+			row = append(row[:0], r.Time.Format(time.RFC3339))
+			for _, c := range columns {
+				row = append(row, c.value(fields))
+			}
+			if err := out.Write(row); err != nil {
+				return err
+			}
+		}
+
+		out.Flush()
+		return out.Error()
+	}
+
+	return errors.New("unknown export format: " + format)
+}
+
+func (app *application) exportDialogShow() {
+	serials, err := app.store.stations()
+	if err != nil {
+		dialog.ShowError(err, app.window)
+		return
+	}
+	if len(serials) == 0 {
+		dialog.ShowInformation("Export observations", "No observation recorded yet.", app.window)
+		return
+	}
+
+	station := widget.NewSelect(serials, nil)
+	station.SetSelected(serials[0])
+
+	now := time.Now()
+	from := widget.NewEntry()
+	from.SetText(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).Format(exportTimeLayout))
+	from.Validator = validateExportTime
+	to := widget.NewEntry()
+	to.SetText(now.Format(exportTimeLayout))
+	to.Validator = validateExportTime
+
+	format := widget.NewRadioGroup([]string{exportCSV, exportJSONLines}, nil)
+	format.Horizontal = true
+	format.SetSelected(exportCSV)
+
+	units := map[*unitQuantity]*widget.Select{}
+	unitItems := []*widget.FormItem{}
+	for _, q := range app.units.quantities() {
+		s := widget.NewSelect(q.units, nil)
+		s.SetSelected(q.get())
+		units[q] = s
+		unitItems = append(unitItems, &widget.FormItem{Text: q.name, Widget: s})
+	}
+
+	checks := map[string]*widget.Check{}
+	columns := container.NewVBox()
+	for _, section := range cardSections {
+		columns.Add(widget.NewLabelWithStyle(section.name, fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+		for _, field := range section.fields {
+			check := widget.NewCheck(field.label, nil)
+			check.SetChecked(field.format != "")
+			checks[field.key] = check
+			columns.Add(check)
+		}
+	}
+	scroll := container.NewVScroll(columns)
+	scroll.SetMinSize(fyne.NewSize(0, 200))
+
+	items := []*widget.FormItem{
+		{Text: "Station", Widget: station},
+		{Text: "From", Widget: from, HintText: "YYYY-MM-DD HH:MM, local time"},
+		{Text: "To", Widget: to},
+		{Text: "Format", Widget: format},
+	}
+	csvOnly := append(unitItems, &widget.FormItem{Text: "Columns", Widget: scroll})
+
+	// The units and columns are only part of the form for CSV exports
+	form := &widget.Form{Items: append(items, csvOnly...), SubmitText: "Export…", CancelText: "Cancel"}
+	format.OnChanged = func(selected string) {
+		form.Items = items
+		if selected == exportCSV {
+			form.Items = append(items, csvOnly...)
+		}
+		form.Refresh()
+	}
+
+	d := dialog.NewCustomWithoutButtons("Export observations", form, app.window)
+	form.OnCancel = d.Hide
+	form.OnSubmit = func() {
+		d.Hide()
+
+		start, _ := time.ParseInLocation(exportTimeLayout, from.Text, time.Local)
+		end, _ := time.ParseInLocation(exportTimeLayout, to.Text, time.Local)
+		records, err := app.store.read(station.Selected, start, end)
+		if err != nil {
+			dialog.ShowError(err, app.window)
+			return
+		}
+
+		selected := []exportColumn{}
+		if format.Selected == exportCSV {
+			for _, section := range cardSections {
+				for _, field := range section.fields {
+					if !checks[field.key].Checked {
+						continue
+					}
+
+					column := exportColumn{field: field, unit: field.unit}
+					if field.quantity != nil {
+						q := field.quantity(app.units)
+						unit := units[q].Selected
+						column.unit = unit + field.unit
+						column.convert = func(v float64) float64 { return q.convert(v, unit) }
+					}
+					selected = append(selected, column)
+				}
+			}
+		}
+
+		app.exportSaveShow(records, format.Selected, selected, "ST-"+station.Selected+"-"+start.Format("20060102"))
+	}
+	d.Resize(fyne.NewSize(400, 600))
+	d.Show()
+}
+
+func validateExportTime(text string) error {
+	_, err := time.ParseInLocation(exportTimeLayout, text, time.Local)
+	return err
+}
+
+func (app *application) exportSaveShow(records []observationRecord, format string, columns []exportColumn, name string) {
+	extension := ".csv"
+	if format == exportJSONLines {
+		extension = ".jsonl"
+	}
+
+	save := dialog.NewFileSave(func(w fyne.URIWriteCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, app.window)
+			return
+		}
+		if w == nil {
+			return
+		}
+
+		err = exportObservations(w, records, format, columns)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			dialog.ShowError(err, app.window)
+			return
+		}
+
+		dialog.ShowInformation("Export observations", fmt.Sprintf("%d observation(s) exported.", len(records)), app.window)
+	}, app.window)
+	save.SetFileName(name + extension)
+	save.SetFilter(storage.NewExtensionFileFilter([]string{extension}))
+	save.Show()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func sectionField(t *testing.T, key string) observationField {
+	t.Helper()
+
+	for _, section := range cardSections {
+		for _, field := range section.fields {
+			if field.key == key {
+				return field
+			}
+		}
+	}
+	t.Fatalf("no field %q in the card sections", key)
+	return observationField{}
+}
+
+// storedObservations records the payloads one minute apart in a temporary store
+// and reads them back.
+func storedObservations(t *testing.T, payloads ...string) []observationRecord {
+	t.Helper()
+
+	store := newObservationStore(t.TempDir(), 7)
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for i, payload := range payloads {
+		if err := store.append("1234", start.Add(time.Duration(i)*time.Minute), payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := store.read("1234", start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(payloads) {
+		t.Fatalf("read %d records, want %d", len(records), len(payloads))
+	}
+	return records
+}
+
+func TestExportCSV(t *testing.T) {
+	records := storedObservations(t,
+		`{"air_temperature":20,"precipitation_type":"None","relative_humidity":55.5}`,
+		`{"air_temperature":-5.5,"precipitation_type":"Snow"}`,
+	)
+
+	columns := []exportColumn{
+		{field: sectionField(t, "air_temperature"), unit: "°F", convert: func(v float64) float64 { return convertTemperature(v, "°F") }},
+		{field: sectionField(t, "precipitation_type")},
+		{field: sectionField(t, "relative_humidity"), unit: "%"},
+	}
+
+	var out bytes.Buffer
+	if err := exportObservations(&out, records, exportCSV, columns); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"time", "air_temperature (°F)", "precipitation_type", "relative_humidity (%)"},
+		{records[0].Time.Format(time.RFC3339), "68", "None", "55.5"},
+		{records[1].Time.Format(time.RFC3339), "22.1", "Snow", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("exported %d rows, want %d: %v", len(rows), len(want), rows)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("row %d column %d = %q, want %q", i, j, rows[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestExportJSONLines(t *testing.T) {
+	records := storedObservations(t,
+		`{"air_temperature":20,"wind_speed":3.2}`,
+		`{"air_temperature":19.5,"nested":{"a":[1,2]}}`,
+	)
+
+	var out bytes.Buffer
+	if err := exportObservations(&out, records, exportJSONLines, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bufio.NewScanner(&out)
+	i := 0
+	for ; lines.Scan(); i++ {
+		var r observationRecord
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if i >= len(records) {
+			continue
+		}
+		if !r.Time.Equal(records[i].Time) {
+			t.Errorf("line %d time = %v, want %v", i, r.Time, records[i].Time)
+		}
+		if !bytes.Equal(r.Payload, records[i].Payload) {
+			t.Errorf("line %d payload = %s, want %s", i, r.Payload, records[i].Payload)
+		}
+	}
+	if i != len(records) {
+		t.Errorf("exported %d lines, want %d", i, len(records))
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if err := exportObservations(&bytes.Buffer{}, nil, "XML", nil); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+	xbinding "fyne.io/x/fyne/data/binding"
+)
+
+const (
+	formatNumber   = "number"
+	formatString   = "string"
+	formatJSON     = "json"
+	formatTemplate = "template"
+
+	// fieldTopicsDelay gathers the fields published together in one observation
+	fieldTopicsDelay = time.Second
+)
+
+// reader returns the function reading the payloads of a field topic according to its format.
+func (f fieldMapping) reader() (func(string) (interface{}, bool), error) {
+	if f.Format != formatTemplate {
+		return f.value, nil
+	}
+
+	t, err := compileValueTemplate(f.Template)
+	if err != nil {
+		return nil, err
+	}
+	return func(payload string) (interface{}, bool) {
+		v, err := t.render(payload)
+		if err != nil {
+			return nil, false
+		}
+		// Templates rendering text around a number still give a number
+		if s, ok := v.(string); ok {
+			if number, err := strconv.ParseFloat(s, 64); err == nil {
+				return number, true
+			}
+		}
+		return v, true
+	}, nil
+}
+
+// value reads the payload of a field topic in one of the fixed formats.
+func (f fieldMapping) value(payload string) (interface{}, bool) {
+	switch f.Format {
+	case formatString:
+		return payload, true
+	case formatJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(payload), &v); err != nil {
+			return nil, false
+		}
+		return lookupPath(v, f.Path)
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	return number, err == nil
+}
+
+// fieldTopics assembles the values published on one topic per field into
+// weatherflow2mqtt observations.
+type fieldTopics struct {
+	lock    sync.Mutex
+	values  map[string]interface{}
+	updated map[string]time.Time
+	pending *time.Timer
+	closed  bool
+
+	// expiry drops the fields whose topic stopped publishing for as long as a card goes stale
+	expiry func() time.Duration
+
+	sources     []xbinding.StringCloser
+	observation binding.String
+}
+
+// bindFieldTopics subscribes to the topic of every field of the mapping.
+func (card *weatherCard) bindFieldTopics(client mqttClient, fields []fieldMapping) (binding.String, error) {
+	ft := &fieldTopics{values: map[string]interface{}{}, updated: map[string]time.Time{}, expiry: card.staleAfter, observation: binding.NewString()}
+
+	for _, f := range fields {
+		if err := validateFieldTopic(f.Topic); err != nil {
+			ft.close()
+			return nil, fmt.Errorf("%s topic: %w", f.Key, err)
+		}
+		read, err := f.reader()
+		if err != nil {
+			ft.close()
+			return nil, fmt.Errorf("%s: %w", f.Key, err)
+		}
+
+		source, err := newMqttString(client, strings.ReplaceAll(f.Topic, "{serial}", card.serial))
+		if err != nil {
+			ft.close()
+			return nil, err
+		}
+		ft.sources = append(ft.sources, source)
+
+		source.AddListener(binding.NewDataListener(func() {
+			payload, err := source.Get()
+			if err != nil || payload == "" {
+				return
+			}
+			if v, ok := read(payload); ok {
+				ft.set(f.Key, f.convert(v))
+			}
+		}))
+	}
+
+	card.fieldTopics = ft
+	return ft.observation, nil
+}
+
+func (ft *fieldTopics) set(key string, v interface{}) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	if ft.closed {
+		return
+	}
+	ft.values[key] = v
+	ft.updated[key] = time.Now()
+	if ft.pending != nil {
+		return
+	}
+	ft.pending = time.AfterFunc(fieldTopicsDelay, ft.publish)
+}
+
+func (ft *fieldTopics) publish() {
+	ft.lock.Lock()
+	if ft.closed {
+		ft.lock.Unlock()
+		return
+	}
+	ft.pending = nil
+	ft.expire(time.Now())
+	data, err := json.Marshal(ft.values)
+	ft.lock.Unlock()
+
+	if err != nil {
+		fyne.LogError("Unable to assemble the observation", err)
+		return
+	}
+	ft.observation.Set(string(data))
+}
+
+// expire drops the values not updated within the expiry, with the lock held.
+func (ft *fieldTopics) expire(now time.Time) {
+	expiry := ft.expiry()
+	for key, updated := range ft.updated {
+		if now.Sub(updated) > expiry {
+			delete(ft.values, key)
+			delete(ft.updated, key)
+		}
+	}
+}
+
+func (ft *fieldTopics) close() {
+	ft.lock.Lock()
+	ft.closed = true
+	if ft.pending != nil {
+		ft.pending.Stop()
+		ft.pending = nil
+	}
+	ft.lock.Unlock()
+
+	for _, source := range ft.sources {
+		source.Close()
+	}
+	ft.sources = nil
+}
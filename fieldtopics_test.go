@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/data/binding"
+)
+
+func TestFieldTopicsExpire(t *testing.T) {
+	now := time.Now()
+	ft := &fieldTopics{
+		values:  map[string]interface{}{"air_temperature": 21.5, "wind_speed": 3.0},
+		updated: map[string]time.Time{"air_temperature": now.Add(-9 * time.Minute), "wind_speed": now.Add(-11 * time.Minute)},
+		expiry:  func() time.Duration { return 10 * time.Minute },
+	}
+
+	ft.expire(now)
+	if _, ok := ft.values["wind_speed"]; ok {
+		t.Error("wind_speed not updated for too long is still published")
+	}
+	if _, ok := ft.updated["wind_speed"]; ok {
+		t.Error("wind_speed update time is still kept")
+	}
+	if ft.values["air_temperature"] != 21.5 {
+		t.Errorf("air_temperature = %v, want 21.5", ft.values["air_temperature"])
+	}
+}
+
+func TestFieldTopicsCloseStopsPublish(t *testing.T) {
+	ft := &fieldTopics{values: map[string]interface{}{}, updated: map[string]time.Time{}, observation: binding.NewString()}
+
+	ft.set("air_temperature", 21.5)
+	if ft.pending == nil {
+		t.Fatal("no publish pending after a value was set")
+	}
+	ft.close()
+	if ft.pending != nil {
+		t.Error("publish still pending after close")
+	}
+
+	ft.set("wind_speed", 3.0)
+	ft.publish()
+	if observation, _ := ft.observation.Get(); observation != "" {
+		t.Errorf("observation %q published after close", observation)
+	}
+}
+
+func TestBindFieldTopicsRejectsWildcards(t *testing.T) {
+	for _, tt := range []struct {
+		topic string
+		ok    bool
+	}{
+		{"weather/{serial}/temperature", true},
+		{"weather/+/temperature", false},
+		{"weather/{serial}/#", false},
+		{"#", false},
+		{"", false},
+	} {
+		topic := tt.topic
+		client := newFakeClient()
+		card := &weatherCard{serial: "1234", staleAfter: func() time.Duration { return defaultStaleAfter }}
+
+		_, err := card.bindFieldTopics(client, []fieldMapping{
+			{Key: "wind_speed", Topic: "weather/{serial}/wind", Format: formatNumber, Scale: 1},
+			{Key: "air_temperature", Topic: topic, Format: formatNumber, Scale: 1},
+		})
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: bind error %v, want valid %v", topic, err, tt.ok)
+		}
+		if err != nil && client.subscriptions() != 0 {
+			t.Errorf("%q: %d subscriptions left after the error", topic, client.subscriptions())
+		}
+		if err == nil {
+			if !client.subscribed("weather/1234/temperature") || client.subscriptions() != 2 {
+				t.Errorf("%q: serial not replaced in the field topics", topic)
+			}
+			card.fieldTopics.close()
+		}
+	}
+}
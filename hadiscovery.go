@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// haIdentifiers accepts the device identifiers as a single string or a list.
+type haIdentifiers []string
+
+func (ids *haIdentifiers) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*ids = haIdentifiers{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*ids = many
+	return nil
+}
+
+// haSensorConfig is a Home Assistant MQTT discovery message of a sensor, whose
+// keys can be abbreviated.
+type haSensorConfig struct {
+	Name          string `json:"name"`
+	ObjectID      string `json:"object_id"`
+	UniqueID      string `json:"unique_id"`
+	StateTopic    string `json:"state_topic"`
+	Unit          string `json:"unit_of_measurement"`
+	DeviceClass   string `json:"device_class"`
+	ValueTemplate string `json:"value_template"`
+	Device        struct {
+		Identifiers  haIdentifiers `json:"identifiers"`
+		Name         string        `json:"name"`
+		Manufacturer string        `json:"manufacturer"`
+		Model        string        `json:"model"`
+	} `json:"device"`
+}
+
+// haAbbreviations are the abbreviated keys of the discovery messages we read.
+var haAbbreviations = map[string]string{
+	"dev":          "device",
+	"dev_cla":      "device_class",
+	"obj_id":       "object_id",
+	"stat_t":       "state_topic",
+	"uniq_id":      "unique_id",
+	"unit_of_meas": "unit_of_measurement",
+	"val_tpl":      "value_template",
+}
+
+// haDeviceAbbreviations are the abbreviated keys of the device of a discovery message.
+var haDeviceAbbreviations = map[string]string{
+	"ids": "identifiers",
+	"mdl": "model",
+	"mf":  "manufacturer",
+}
+
+func (c *haSensorConfig) UnmarshalJSON(data []byte) error {
+	expanded, err := expandHAConfig(data)
+	if err != nil {
+		return err
+	}
+
+	type config haSensorConfig // without this method
+	return json.Unmarshal(expanded, (*config)(c))
+}
+
+// expandHAConfig writes a discovery message with its keys in full and the ~
+// at the start or end of its topics replaced by the base topic.
+func expandHAConfig(data []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	expandHAKeys(fields, haAbbreviations)
+
+	if device, ok := fields["device"]; ok {
+		var deviceFields map[string]json.RawMessage
+		if err := json.Unmarshal(device, &deviceFields); err == nil {
+			expandHAKeys(deviceFields, haDeviceAbbreviations)
+			if fields["device"], err = json.Marshal(deviceFields); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var base string
+	if raw, ok := fields["~"]; ok && json.Unmarshal(raw, &base) == nil {
+		for key, raw := range fields {
+			var topic string
+			if !strings.HasSuffix(key, "_topic") || json.Unmarshal(raw, &topic) != nil {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(topic, "~"):
+				topic = base + topic[1:]
+			case strings.HasSuffix(topic, "~"):
+				topic = topic[:len(topic)-1] + base
+			}
+			expanded, err := json.Marshal(topic)
+			if err != nil {
+				return nil, err
+			}
+			fields[key] = expanded
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// expandHAKeys renames the abbreviated keys, the full key wins when both are set.
+func expandHAKeys(fields map[string]json.RawMessage, abbreviations map[string]string) {
+	for short, full := range abbreviations {
+		v, ok := fields[short]
+		if !ok {
+			continue
+		}
+		if _, set := fields[full]; !set {
+			fields[full] = v
+		}
+		delete(fields, short)
+	}
+}
+
+// haDevice groups the weather sensors of one Home Assistant device.
+type haDevice struct {
+	id      string
+	name    string
+	model   string
+	sensors map[string]haSensorConfig // by discovery topic
+}
+
+var haSerialCleaner = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// haSerial turns a device identifier into a serial usable in topics and file
+// names, apart from the serials of Tempest stations.
+func haSerial(id string) string {
+	return "ha_" + haSerialCleaner.ReplaceAllString(id, "_")
+}
+
+// haFieldKey returns the observation field a sensor provides, or "" when it is not weather related.
+func haFieldKey(c haSensorConfig) string {
+	name := strings.ToLower(c.Name + " " + c.ObjectID + " " + c.UniqueID)
+	has := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(name, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch c.DeviceClass {
+	case "temperature":
+		switch {
+		case has("dew"):
+			return "dewpoint"
+		case has("feel", "apparent", "chill", "heat_index", "heat index"):
+			return "feelslike"
+		case has("wet"):
+			return "wetbulb"
+		}
+		return "air_temperature"
+	case "humidity":
+		return "relative_humidity"
+	case "pressure", "atmospheric_pressure":
+		if has("station", "absolute", "abs") {
+			return "station_pressure"
+		}
+		return "sealevel_pressure"
+	case "wind_speed":
+		switch {
+		case has("gust"):
+			return "wind_gust"
+		case has("lull"):
+			return "wind_lull"
+		}
+		return "wind_speed"
+	case "wind_direction":
+		return "wind_direction"
+	case "precipitation":
+		return "rain_today"
+	case "precipitation_intensity":
+		return "rain_rate"
+	case "illuminance":
+		return "illuminance"
+	case "irradiance":
+		return "solar_radiation"
+	}
+	return ""
+}
+
+// haUnit returns the unit of a sensor as understood by toPublishedUnit and the scale to apply first.
+func haUnit(unit string) (string, float64) {
+	switch unit {
+	case "°F", "mph", "m/s", "kn", "inHg", "mmHg", "in", "mi":
+		return unit, 1
+	case "in/h":
+		return "in", 1
+	case "kPa":
+		return "", 10
+	case "Pa":
+		return "", 0.01
+	case "ft/s":
+		return "m/s", 0.3048
+	}
+	return "", 1
+}
+
+// mapping returns the field mapping reading every weather sensor of the
+// device from its own state topic.
+func (d *haDevice) mapping() stationMapping {
+	m := stationMapping{Preset: presetCustom, Mode: mappingTopics, Name: d.name, Origin: originHADiscovery}
+
+	topics := make([]string, 0, len(d.sensors))
+	for topic := range d.sensors {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	used := map[string]bool{}
+	for _, topic := range topics {
+		c := d.sensors[topic]
+		key := haFieldKey(c)
+		if used[key] {
+			continue
+		}
+		used[key] = true
+
+		unit, scale := haUnit(c.Unit)
+		f := fieldMapping{Key: key, Topic: c.StateTopic, Format: formatNumber, Scale: scale, Unit: unit}
+		if c.ValueTemplate != "" {
+			f.Format, f.Template = formatTemplate, c.ValueTemplate
+		}
+		m.Fields = append(m.Fields, f)
+	}
+
+	return m
+}
+
+// haConfigTopic is the subscription receiving the discovery messages of sensors.
+func haConfigTopic(prefix string) string {
+	return prefix + "/sensor/+/+/config"
+}
+
+// handleConfig collects the weather sensors announced through Home Assistant discovery.
+func (sd *stationDiscovery) handleConfig(msg mqtt.Message) {
+	// weatherflow2mqtt announces its sensors too, those stations are found on their status topic
+	if sd.match.MatchString(msg.Topic()) {
+		return
+	}
+
+	var c haSensorConfig
+	if err := json.Unmarshal(msg.Payload(), &c); err != nil || c.StateTopic == "" || haFieldKey(c) == "" {
+		return
+	}
+	if _, err := compileValueTemplate(c.ValueTemplate); err != nil {
+		fyne.LogError("Unsupported value template of "+msg.Topic(), err)
+		return
+	}
+	if len(c.Device.Identifiers) == 0 {
+		return
+	}
+
+	id := c.Device.Identifiers[0]
+	serial := haSerial(id)
+
+	sd.lock.Lock()
+	s, ok := sd.stations[serial]
+	if !ok {
+		s = &discoveredStation{serial: serial, device: &haDevice{id: id, sensors: map[string]haSensorConfig{}}}
+		sd.stations[serial] = s
+	}
+	if s.device == nil {
+		sd.lock.Unlock()
+		return
+	}
+	s.device.name = orDefault(c.Device.Name, id)
+	s.device.model = strings.TrimSpace(c.Device.Manufacturer + " " + c.Device.Model)
+	s.device.sensors[msg.Topic()] = c
+	s.attribution = s.device.model
+	s.lastSeen = time.Now()
+	count := len(sd.stations)
+	sd.lock.Unlock()
+
+	if !ok {
+		sd.found(count)
+	}
+	sd.first.complete(nil)
+}
+
+// rememberDevice saves how to read the sensors of a station found through
+// Home Assistant discovery, before its card is built. A mapping the user
+// already has for the station is kept.
+func (app *application) rememberDevice(s discoveredStation) {
+	if s.device == nil || app.app.Preferences().String(stationMappingKey(s.serial)) != "" {
+		return
+	}
+	app.saveStationMapping(s.serial, s.device.mapping())
+}
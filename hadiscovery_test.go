@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"fyne.io/fyne/v2/test"
+)
+
+// Discovery messages as published by ESPHome, with abbreviated keys and a base topic
+const (
+	espTemperatureConfig = `{"dev_cla":"temperature","unit_of_meas":"°C","stat_cla":"measurement","name":"Outside Temperature",` +
+		`"stat_t":"weather-esp/sensor/outside_temperature/state","avty_t":"weather-esp/status","uniq_id":"ESPsensoroutside_temperature",` +
+		`"dev":{"ids":"a4cf12ab34cd","name":"weather-esp","sw":"esphome v2023.6.0","mdl":"esp32dev","mf":"espressif"}}`
+	espHumidityConfig = `{"~":"weather-esp/sensor/outside_humidity","stat_t":"~/state","dev_cla":"humidity","unit_of_meas":"%",` +
+		`"name":"Outside Humidity","uniq_id":"ESPsensoroutside_humidity","dev":{"ids":["a4cf12ab34cd"],"name":"weather-esp","mf":"espressif","mdl":"esp32dev"}}`
+	espBatteryConfig = `{"dev_cla":"battery","unit_of_meas":"%","name":"Battery","stat_t":"weather-esp/sensor/battery/state",` +
+		`"dev":{"ids":"a4cf12ab34cd","name":"weather-esp"}}`
+)
+
+// Discovery messages as published by ecowitt2mqtt and rtl_433_mqtt_hass, with the keys in full
+const (
+	ecowittGustConfig = `{"name":"Wind gust","state_topic":"ecowitt2mqtt/gw1100/windgustmph","unit_of_measurement":"mph",` +
+		`"device_class":"wind_speed","unique_id":"gw1100_windgustmph","object_id":"gw1100_windgust",` +
+		`"device":{"identifiers":["gw1100"],"manufacturer":"Ecowitt","model":"GW1100","name":"GW1100"}}`
+	rtl433PressureConfig = `{"device_class":"pressure","name":"Pressure","unit_of_measurement":"kPa","value_template":"{{ value|float }}",` +
+		`"state_class":"measurement","state_topic":"rtl_433/host/devices/Fineoffset-WH65B/60/pressure_kPa","unique_id":"Fineoffset-WH65B-60-P",` +
+		`"device":{"identifiers":"Fineoffset-WH65B-60","name":"Fineoffset-WH65B-60","model":"Fineoffset-WH65B","manufacturer":"rtl_433"}}`
+)
+
+func TestRememberDeviceKeepsUserMapping(t *testing.T) {
+	app := &application{app: test.NewTempApp(t)}
+	device := &haDevice{id: "station", name: "Garden", sensors: map[string]haSensorConfig{
+		"homeassistant/sensor/station/temperature/config": {StateTopic: "garden/temperature", DeviceClass: "temperature", Unit: "°C"},
+	}}
+	station := discoveredStation{serial: haSerial(device.id), device: device}
+
+	app.rememberDevice(station)
+	if m := app.loadStationMapping(station.serial); m.Name != "Garden" || len(m.Fields) != 1 {
+		t.Fatalf("discovered mapping not saved: %+v", m)
+	}
+
+	edited := app.loadStationMapping(station.serial)
+	edited.Fields[0].Topic = "garden/outside"
+	app.saveStationMapping(station.serial, edited)
+
+	app.rememberDevice(station)
+	if m := app.loadStationMapping(station.serial); m.Fields[0].Topic != "garden/outside" {
+		t.Errorf("user mapping overwritten by discovery: %+v", m)
+	}
+}
+
+func TestHASensorConfigAbbreviations(t *testing.T) {
+	for name, tt := range map[string]struct {
+		payload string
+		want    haSensorConfig
+	}{
+		"abbreviated": {espTemperatureConfig, haSensorConfig{Name: "Outside Temperature", UniqueID: "ESPsensoroutside_temperature",
+			StateTopic: "weather-esp/sensor/outside_temperature/state", Unit: "°C", DeviceClass: "temperature"}},
+		"base topic first": {espHumidityConfig, haSensorConfig{Name: "Outside Humidity", UniqueID: "ESPsensoroutside_humidity",
+			StateTopic: "weather-esp/sensor/outside_humidity/state", Unit: "%", DeviceClass: "humidity"}},
+		"base topic last": {`{"~":"outside/temperature","stat_t":"weather-esp/~","dev_cla":"temperature","obj_id":"outside","val_tpl":"{{ value }}"}`,
+			haSensorConfig{ObjectID: "outside", StateTopic: "weather-esp/outside/temperature", DeviceClass: "temperature", ValueTemplate: "{{ value }}"}},
+		"full keys": {ecowittGustConfig, haSensorConfig{Name: "Wind gust", ObjectID: "gw1100_windgust", UniqueID: "gw1100_windgustmph",
+			StateTopic: "ecowitt2mqtt/gw1100/windgustmph", Unit: "mph", DeviceClass: "wind_speed"}},
+		"full key wins":    {`{"state_topic":"full","stat_t":"abbreviated"}`, haSensorConfig{StateTopic: "full"}},
+		"~ inside a topic": {`{"~":"base","stat_t":"a/~/b"}`, haSensorConfig{StateTopic: "a/~/b"}},
+	} {
+		var c haSensorConfig
+		if err := json.Unmarshal([]byte(tt.payload), &c); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		device := c.Device
+		c.Device = tt.want.Device
+		if !reflect.DeepEqual(c, tt.want) {
+			t.Errorf("%s: decoded %+v, want %+v", name, c, tt.want)
+		}
+
+		switch name {
+		case "abbreviated", "base topic first":
+			if !reflect.DeepEqual(device.Identifiers, haIdentifiers{"a4cf12ab34cd"}) || device.Name != "weather-esp" ||
+				device.Manufacturer != "espressif" || device.Model != "esp32dev" {
+				t.Errorf("%s: decoded device %+v", name, device)
+			}
+		case "full keys":
+			if !reflect.DeepEqual(device.Identifiers, haIdentifiers{"gw1100"}) || device.Manufacturer != "Ecowitt" || device.Model != "GW1100" {
+				t.Errorf("%s: decoded device %+v", name, device)
+			}
+		}
+	}
+}
+
+func TestHandleConfig(t *testing.T) {
+	topics, err := connectionProfile{}.topics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := []int{}
+	sd := newStationDiscovery(topics, func(count int) { found = append(found, count) })
+
+	for _, msg := range []fakeMessage{
+		{"homeassistant/sensor/weather-esp/outside_temperature/config", []byte(espTemperatureConfig)},
+		{"homeassistant/sensor/weather-esp/outside_humidity/config", []byte(espHumidityConfig)},
+		{"homeassistant/sensor/gw1100/windgustmph/config", []byte(ecowittGustConfig)},
+		{"homeassistant/sensor/Fineoffset-WH65B-60/Fineoffset-WH65B-60-P/config", []byte(rtl433PressureConfig)},
+		// Ignored: not weather related, from weatherflow2mqtt, without state topic, device, or with an unsupported template
+		{"homeassistant/sensor/weather-esp/battery/config", []byte(espBatteryConfig)},
+		{"homeassistant/sensor/weatherflow2mqtt_ST-1234/air_temperature/config",
+			[]byte(`{"name":"Temperature","stat_t":"homeassistant/sensor/weatherflow2mqtt_ST-1234/observation/state","dev_cla":"temperature","dev":{"ids":"ST-1234"}}`)},
+		{"homeassistant/sensor/other/temperature/config", []byte(`{"dev_cla":"temperature","dev":{"ids":"other"}}`)},
+		{"homeassistant/sensor/other/temperature/config", []byte(`{"dev_cla":"temperature","stat_t":"other/temperature"}`)},
+		{"homeassistant/sensor/other/temperature/config", []byte(`{"dev_cla":"temperature","stat_t":"other/temperature","val_tpl":"{{ value | timestamp_local }}","dev":{"ids":"other"}}`)},
+		{"homeassistant/sensor/other/temperature/config", []byte(`not json`)},
+	} {
+		sd.handleConfig(&msg)
+	}
+
+	if !reflect.DeepEqual(found, []int{1, 2, 3}) {
+		t.Errorf("stations found %v, want 1, 2 then 3", found)
+	}
+	select {
+	case <-sd.first.Done():
+	default:
+		t.Error("first station not signaled")
+	}
+
+	for serial, want := range map[string]struct {
+		name, model string
+		topics      []string
+	}{
+		"ha_a4cf12ab34cd":        {"weather-esp", "espressif esp32dev", []string{"weather-esp/sensor/outside_temperature/state", "weather-esp/sensor/outside_humidity/state"}},
+		"ha_gw1100":              {"GW1100", "Ecowitt GW1100", []string{"ecowitt2mqtt/gw1100/windgustmph"}},
+		"ha_Fineoffset-WH65B-60": {"Fineoffset-WH65B-60", "rtl_433 Fineoffset-WH65B", []string{"rtl_433/host/devices/Fineoffset-WH65B/60/pressure_kPa"}},
+	} {
+		s, ok := sd.stations[serial]
+		if !ok || s.device == nil {
+			t.Errorf("%s not found", serial)
+			continue
+		}
+		if s.device.name != want.name || s.device.model != want.model || s.attribution != want.model {
+			t.Errorf("%s: device %q model %q", serial, s.device.name, s.device.model)
+		}
+		stateTopics := []string{}
+		for _, c := range s.device.sensors {
+			stateTopics = append(stateTopics, c.StateTopic)
+		}
+		if len(stateTopics) != len(want.topics) {
+			t.Errorf("%s: sensors %v, want %v", serial, stateTopics, want.topics)
+		}
+		for _, topic := range want.topics {
+			found := false
+			for _, stateTopic := range stateTopics {
+				found = found || stateTopic == topic
+			}
+			if !found {
+				t.Errorf("%s: sensor %s missing", serial, topic)
+			}
+		}
+	}
+	if len(sd.stations) != 3 {
+		t.Errorf("%d stations found, want 3", len(sd.stations))
+	}
+
+	// A Tempest station found on its status topic is not turned into a device
+	sd.stations["5678"] = &discoveredStation{serial: "5678"}
+	sd.handleConfig(&fakeMessage{"homeassistant/sensor/x/temperature/config", []byte(`{"dev_cla":"temperature","stat_t":"x","dev":{"ids":"5678"}}`)})
+	if sd.stations["5678"].device != nil {
+		t.Error("station found on its status topic turned into a device")
+	}
+}
+
+func TestHAFieldKey(t *testing.T) {
+	for _, tt := range []struct {
+		config haSensorConfig
+		key    string
+	}{
+		{haSensorConfig{DeviceClass: "temperature", Name: "Outside Temperature"}, "air_temperature"},
+		{haSensorConfig{DeviceClass: "temperature", Name: "Dew Point"}, "dewpoint"},
+		{haSensorConfig{DeviceClass: "temperature", ObjectID: "feels_like"}, "feelslike"},
+		{haSensorConfig{DeviceClass: "temperature", Name: "Wind Chill"}, "feelslike"},
+		{haSensorConfig{DeviceClass: "temperature", UniqueID: "gw1100_heat_index"}, "feelslike"},
+		{haSensorConfig{DeviceClass: "temperature", Name: "Wet bulb"}, "wetbulb"},
+		{haSensorConfig{DeviceClass: "humidity"}, "relative_humidity"},
+		{haSensorConfig{DeviceClass: "pressure", Name: "Relative pressure"}, "sealevel_pressure"},
+		{haSensorConfig{DeviceClass: "atmospheric_pressure", Name: "Absolute pressure"}, "station_pressure"},
+		{haSensorConfig{DeviceClass: "pressure", UniqueID: "station_pressure"}, "station_pressure"},
+		{haSensorConfig{DeviceClass: "wind_speed", Name: "Wind speed"}, "wind_speed"},
+		{haSensorConfig{DeviceClass: "wind_speed", Name: "Wind Gust"}, "wind_gust"},
+		{haSensorConfig{DeviceClass: "wind_speed", ObjectID: "wind_lull"}, "wind_lull"},
+		{haSensorConfig{DeviceClass: "wind_direction"}, "wind_direction"},
+		{haSensorConfig{DeviceClass: "precipitation", Name: "Daily rain"}, "rain_today"},
+		{haSensorConfig{DeviceClass: "precipitation_intensity"}, "rain_rate"},
+		{haSensorConfig{DeviceClass: "illuminance"}, "illuminance"},
+		{haSensorConfig{DeviceClass: "irradiance"}, "solar_radiation"},
+		{haSensorConfig{DeviceClass: "battery"}, ""},
+		{haSensorConfig{Name: "Temperature"}, ""},
+	} {
+		if key := haFieldKey(tt.config); key != tt.key {
+			t.Errorf("haFieldKey(%+v) = %q, want %q", tt.config, key, tt.key)
+		}
+	}
+}
+
+func TestHAUnit(t *testing.T) {
+	for _, tt := range []struct {
+		unit, want string
+		scale      float64
+	}{
+		{"°C", "", 1},
+		{"°F", "°F", 1},
+		{"mph", "mph", 1},
+		{"km/h", "", 1},
+		{"m/s", "m/s", 1},
+		{"ft/s", "m/s", 0.3048},
+		{"hPa", "", 1},
+		{"kPa", "", 10},
+		{"Pa", "", 0.01},
+		{"inHg", "inHg", 1},
+		{"mm", "", 1},
+		{"in", "in", 1},
+		{"in/h", "in", 1},
+		{"%", "", 1},
+	} {
+		if unit, scale := haUnit(tt.unit); unit != tt.want || scale != tt.scale {
+			t.Errorf("haUnit(%q) = %q, %g, want %q, %g", tt.unit, unit, scale, tt.want, tt.scale)
+		}
+	}
+}
+
+func TestHADeviceMapping(t *testing.T) {
+	device := &haDevice{id: "a4cf12ab34cd", name: "weather-esp", sensors: map[string]haSensorConfig{}}
+	for topic, payload := range map[string]string{
+		"homeassistant/sensor/weather-esp/a_temperature/config": espTemperatureConfig,
+		"homeassistant/sensor/weather-esp/b_humidity/config":    espHumidityConfig,
+		"homeassistant/sensor/weather-esp/c_gust/config":        ecowittGustConfig,
+		"homeassistant/sensor/weather-esp/d_pressure/config":    rtl433PressureConfig,
+		// A second temperature sensor is left out, the first one by topic is kept
+		"homeassistant/sensor/weather-esp/e_temperature/config": `{"dev_cla":"temperature","stat_t":"weather-esp/sensor/inside_temperature/state"}`,
+	} {
+		var c haSensorConfig
+		if err := json.Unmarshal([]byte(payload), &c); err != nil {
+			t.Fatal(err)
+		}
+		device.sensors[topic] = c
+	}
+
+	m := device.mapping()
+	if m.Name != "weather-esp" || m.Origin != originHADiscovery || m.Mode != mappingTopics || m.Preset != presetCustom {
+		t.Errorf("mapping %+v", m)
+	}
+	want := []fieldMapping{
+		{Key: "air_temperature", Topic: "weather-esp/sensor/outside_temperature/state", Format: formatNumber, Scale: 1},
+		{Key: "relative_humidity", Topic: "weather-esp/sensor/outside_humidity/state", Format: formatNumber, Scale: 1},
+		{Key: "wind_gust", Topic: "ecowitt2mqtt/gw1100/windgustmph", Format: formatNumber, Scale: 1, Unit: "mph"},
+		{Key: "sealevel_pressure", Topic: "rtl_433/host/devices/Fineoffset-WH65B/60/pressure_kPa", Format: formatTemplate, Template: "{{ value|float }}", Scale: 10},
+	}
+	if !reflect.DeepEqual(m.Fields, want) {
+		t.Errorf("fields %+v, want %+v", m.Fields, want)
+	}
+
+	// The fields of the mapping read the values published on the state topics
+	for i, payload := range map[int]string{0: "21.5", 2: "10", 3: "101.3"} {
+		read, err := m.Fields[i].reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok := read(payload)
+		if !ok {
+			t.Errorf("%s: %s not read", m.Fields[i].Key, payload)
+			continue
+		}
+		got := m.Fields[i].convert(v).(float64)
+		if want := map[int]float64{0: 21.5, 2: 16.09344, 3: 1013}[i]; got < want-0.001 || got > want+0.001 {
+			t.Errorf("%s: %s read as %g, want %g", m.Fields[i].Key, payload, got, want)
+		}
+	}
+}
+
+func TestHASerial(t *testing.T) {
+	for id, serial := range map[string]string{
+		"a4cf12ab34cd":        "ha_a4cf12ab34cd",
+		"Fineoffset-WH65B-60": "ha_Fineoffset-WH65B-60",
+		"gw1100/outdoor unit": "ha_gw1100_outdoor_unit",
+	} {
+		if got := haSerial(id); got != serial {
+			t.Errorf("haSerial(%q) = %q, want %q", id, got, serial)
+		}
+		if !regexp.MustCompile(`^[A-Za-z0-9_-]+$`).MatchString(haSerial(id)) {
+			t.Errorf("haSerial(%q) is not usable in a file name", id)
+		}
+	}
+}
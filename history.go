@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	historyRetentionKey     = "historyRetention"
+	defaultHistoryRetention = 24 * time.Hour
+)
+
+type sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// series keeps the samples of one observation field in time order. It is a
+// plain slice, the samples older than the retention are cut from its front.
+type series struct {
+	samples []sample
+}
+
+func (s *series) add(v sample, oldest time.Time) {
+	s.samples = append(s.samples, v)
+	s.trim(oldest)
+}
+
+func (s *series) trim(oldest time.Time) {
+	drop := sort.Search(len(s.samples), func(i int) bool {
+		return !s.samples[i].Time.Before(oldest)
+	})
+	s.samples = s.samples[drop:]
+}
+
+// observationHistory holds the recent values of every numeric observation field of a station.
+type observationHistory struct {
+	lock      sync.RWMutex
+	retention time.Duration
+	series    map[string]*series
+}
+
+func newObservationHistory(retention time.Duration) *observationHistory {
+	return &observationHistory{retention: retention, series: map[string]*series{}}
+}
+
+// numericFields extracts the numbers of a JSON observation.
+func numericFields(payload string) map[string]float64 {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return nil
+	}
+
+	values := map[string]float64{}
+	for key, v := range fields {
+		if f, ok := v.(float64); ok {
+			values[key] = f
+		}
+	}
+	return values
+}
+
+func (h *observationHistory) record(t time.Time, values map[string]float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	oldest := t.Add(-h.retention)
+	for key, v := range values {
+		s, ok := h.series[key]
+		if !ok {
+			s = &series{}
+			h.series[key] = s
+		}
+		s.add(sample{Time: t, Value: v}, oldest)
+	}
+}
+
+// samples returns a copy of the recorded values of a field.
+func (h *observationHistory) samples(key string) []sample {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		return nil
+	}
+	return append([]sample(nil), s.samples...)
+}
+
+func (h *observationHistory) setRetention(retention time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.retention = retention
+	oldest := time.Now().Add(-retention)
+	for _, s := range h.series {
+		s.trim(oldest)
+	}
+}
+
+func (app *application) historyRetention() time.Duration {
+	hours := app.app.Preferences().IntWithFallback(historyRetentionKey, int(defaultHistoryRetention/time.Hour))
+	return time.Duration(hours) * time.Hour
+}
+
+func (app *application) historyFormItems() ([]*widget.FormItem, func()) {
+	retention := widget.NewSelect([]string{"1", "6", "12", "24", "48", "168"}, nil)
+	retention.SetSelected(strconv.Itoa(int(app.historyRetention() / time.Hour)))
+
+	return []*widget.FormItem{{Text: "History", Widget: retention, HintText: "Hours of observations kept for the charts"}}, func() {
+		hours, err := strconv.Atoi(retention.Selected)
+		if err != nil {
+			fyne.LogError("Invalid history retention", err)
+			return
+		}
+		app.app.Preferences().SetInt(historyRetentionKey, hours)
+
+		for _, card := range app.cardList() {
+			card.history.setRetention(app.historyRetention())
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObservationHistoryRecord(t *testing.T) {
+	h := newObservationHistory(time.Hour)
+	start := time.Now().Add(-2 * time.Hour)
+
+	for i := 0; i <= 12; i++ {
+		at := start.Add(time.Duration(i) * 10 * time.Minute)
+		h.record(at, map[string]float64{"air_temperature": float64(i)})
+		if i%2 == 0 {
+			h.record(at, map[string]float64{"wind_speed": float64(i)})
+		}
+	}
+
+	// Samples older than the retention before the last one are dropped
+	temperatures := h.samples("air_temperature")
+	if len(temperatures) != 7 || temperatures[0].Value != 6 || temperatures[6].Value != 12 {
+		t.Errorf("air_temperature samples %v, want 6 to 12", temperatures)
+	}
+	if winds := h.samples("wind_speed"); len(winds) != 4 || winds[0].Value != 6 {
+		t.Errorf("wind_speed samples %v, want 6 to 12", winds)
+	}
+	if h.samples("uv") != nil {
+		t.Error("samples of a field never recorded")
+	}
+
+	// The samples returned are a copy
+	temperatures[0].Value = 100
+	if h.samples("air_temperature")[0].Value != 6 {
+		t.Error("changing returned samples changed the history")
+	}
+}
+
+func TestObservationHistorySetRetention(t *testing.T) {
+	h := newObservationHistory(24 * time.Hour)
+	now := time.Now()
+	for _, age := range []time.Duration{20 * time.Hour, 5 * time.Hour, 90 * time.Minute, 30 * time.Minute, time.Minute} {
+		h.record(now.Add(-age), map[string]float64{"air_temperature": age.Minutes()})
+	}
+
+	for _, tt := range []struct {
+		retention time.Duration
+		want      []float64
+	}{
+		{24 * time.Hour, []float64{1200, 300, 90, 30, 1}},
+		{6 * time.Hour, []float64{300, 90, 30, 1}},
+		{time.Hour, []float64{30, 1}},
+		// Growing the retention does not bring dropped samples back
+		{24 * time.Hour, []float64{30, 1}},
+		{time.Second, []float64{}},
+	} {
+		h.setRetention(tt.retention)
+		got := h.samples("air_temperature")
+		if len(got) != len(tt.want) {
+			t.Errorf("retention %s keeps %v, want %v", tt.retention, got, tt.want)
+			continue
+		}
+		for i, s := range got {
+			if s.Value != tt.want[i] {
+				t.Errorf("retention %s keeps %v, want %v", tt.retention, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// Recording uses the new retention
+	h.setRetention(time.Hour)
+	h.record(now, map[string]float64{"air_temperature": 0})
+	h.record(now.Add(2*time.Hour), map[string]float64{"air_temperature": -120})
+	if got := h.samples("air_temperature"); len(got) != 1 || got[0].Value != -120 {
+		t.Errorf("samples after recording %v, want only the last one", got)
+	}
+}
+
+func TestNumericFields(t *testing.T) {
+	got := numericFields(`{"air_temperature":21.5,"uv_description":"Low","status":3,"missing":null}`)
+	if len(got) != 2 || got["air_temperature"] != 21.5 || got["status"] != 3 {
+		t.Errorf("numericFields = %v", got)
+	}
+	if numericFields("not json") != nil {
+		t.Error("numbers read from an invalid payload")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	// lightningNearby is the distance in km under which a strike counts as nearby activity
+	lightningNearby = 20
+	// lightningRecent is how long a strike keeps the activity nearby
+	lightningRecent = 30 * time.Minute
+	// lightningLogSize is the number of strikes kept in the log
+	lightningLogSize = 100
+)
+
+// strike is a lightning strike detected from the changes of the observation.
+type strike struct {
+	Time     time.Time
+	Distance float64 // km
+	Energy   float64
+	Count    int // strikes reported since the previous observation
+}
+
+// lightningTracker detects the new strikes between two observations.
+type lightningTracker struct {
+	lock sync.Mutex
+
+	seen      bool
+	lastTime  string
+	lastCount float64
+	strikes   []strike // newest first
+}
+
+// update returns the strike found in the observation, if any.
+func (l *lightningTracker) update(t time.Time, payload string) (strike, bool) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return strike{}, false
+	}
+	count, hasCount := fields["lightning_strike_count"].(float64)
+	when, _ := fields["lightning_strike_time"].(string)
+	distance, _ := fields["lightning_strike_distance"].(float64)
+	energy, _ := fields["lightning_strike_energy"].(float64)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// The first observation only tells us where the counters are, and a
+	// counter going down was reset
+	first := !l.seen
+	changedTime := when != "" && when != l.lastTime
+	increased := hasCount && count > l.lastCount
+	previous := l.lastCount
+	l.seen = true
+	l.lastTime = when
+	if hasCount {
+		l.lastCount = count
+	}
+	if first || (!changedTime && !increased) {
+		return strike{}, false
+	}
+
+	s := strike{Time: t, Distance: distance, Energy: energy, Count: 1}
+	if parsed, err := time.Parse(time.RFC3339, when); err == nil {
+		s.Time = parsed
+	}
+	if increased && count-previous > 1 {
+		s.Count = int(count - previous)
+	}
+
+	l.strikes = append([]strike{s}, l.strikes...)
+	if len(l.strikes) > lightningLogSize {
+		l.strikes = l.strikes[:lightningLogSize]
+	}
+	return s, true
+}
+
+func (l *lightningTracker) log() []strike {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]strike(nil), l.strikes...)
+}
+
+// lightningPanel shows the latest strike, the strike counts and the strike log.
+type lightningPanel struct {
+	tracker *lightningTracker
+	units   *unitSettings
+
+	icon    *canvas.Image
+	latest  *widget.Label
+	counts  *widget.Label
+	list    *widget.List
+	content fyne.CanvasObject
+
+	lock    sync.Mutex // strikes is updated by the observations and read by the list
+	strikes []strike
+}
+
+func newLightningPanel(units *unitSettings) *lightningPanel {
+	p := &lightningPanel{tracker: &lightningTracker{}, units: units,
+		icon:   canvas.NewImageFromResource(weatherCloudyLightning),
+		latest: widget.NewLabel("No strike detected"),
+		counts: widget.NewLabel("-"),
+	}
+	p.icon.FillMode = canvas.ImageFillContain
+	p.icon.SetMinSize(fyne.NewSize(32, 32))
+	p.icon.Hide()
+
+	p.list = widget.NewList(func() int { return len(p.log()) },
+		func() fyne.CanvasObject { return widget.NewLabel("00:00:00  000.0 km, energy 000000") },
+		func(id widget.ListItemID, o fyne.CanvasObject) {
+			strikes := p.log()
+			if id >= len(strikes) {
+				return
+			}
+			s := strikes[id]
+			text := fmt.Sprintf("%s  %s, energy %.0f", s.Time.Local().Format("15:04:05"), p.units.distance.format("%.0f")(s.Distance), s.Energy)
+			if s.Count > 1 {
+				text += fmt.Sprintf(" (%d strikes)", s.Count)
+			}
+			o.(*widget.Label).SetText(text)
+		})
+	log := container.NewVScroll(p.list)
+	log.SetMinSize(fyne.NewSize(0, 120))
+
+	p.content = container.NewVBox(
+		container.NewBorder(nil, nil, p.icon, nil, p.latest),
+		p.counts,
+		widget.NewLabelWithStyle("Strike log", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		log)
+	return p
+}
+
+// update detects the strikes of a new observation and refreshes the panel.
+func (p *lightningPanel) update(t time.Time, payload string) {
+	p.tracker.update(t, payload)
+
+	var counts struct {
+		Hour  float64 `json:"lightning_strike_count_1hr"`
+		Hours float64 `json:"lightning_strike_count_3hr"`
+		Today float64 `json:"lightning_strike_count_today"`
+	}
+	if err := json.Unmarshal([]byte(payload), &counts); err == nil {
+		p.counts.SetText(fmt.Sprintf("%.0f in the last hour, %.0f in 3 hours, %.0f today", counts.Hour, counts.Hours, counts.Today))
+	}
+
+	p.lock.Lock()
+	p.strikes = p.tracker.log()
+	p.lock.Unlock()
+	p.list.Refresh()
+
+	p.refreshLatest(t)
+}
+
+// log returns the strikes shown in the list, newest first.
+func (p *lightningPanel) log() []strike {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.strikes
+}
+
+// refreshLatest shows how long ago the last strike was, it is called for
+// every observation and by the freshness ticker.
+func (p *lightningPanel) refreshLatest(now time.Time) {
+	strikes := p.log()
+	if len(strikes) == 0 {
+		p.latest.SetText("No strike detected")
+		p.icon.Hide()
+		return
+	}
+
+	last := strikes[0]
+	age := max(0, now.Sub(last.Time).Truncate(time.Minute))
+	p.latest.SetText(fmt.Sprintf("Last strike %s away, %s ago", p.units.distance.format("%.0f")(last.Distance), age))
+
+	if age < lightningRecent && last.Distance <= lightningNearby {
+		p.icon.Resource = weatherCloudyLightning
+		if last.Distance > lightningNearby/2 {
+			p.icon.Resource = weatherPartlyCloudyLightning
+		}
+		p.icon.Show()
+		p.icon.Refresh()
+	} else {
+		p.icon.Hide()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/test"
+)
+
+func TestLightningTrackerUpdate(t *testing.T) {
+	l := &lightningTracker{}
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i, tt := range []struct {
+		payload string
+		found   bool
+		count   int
+		when    time.Time
+	}{
+		// The first observation only sets the counters
+		{`{"lightning_strike_count":3,"lightning_strike_time":"2024-06-01T11:50:00+00:00","lightning_strike_distance":12}`, false, 0, time.Time{}},
+		// A duplicate payload is no new strike
+		{`{"lightning_strike_count":3,"lightning_strike_time":"2024-06-01T11:50:00+00:00","lightning_strike_distance":12}`, false, 0, time.Time{}},
+		{`not json`, false, 0, time.Time{}},
+		{`{"lightning_strike_count":5,"lightning_strike_time":"2024-06-01T12:02:00+00:00","lightning_strike_distance":8,"lightning_strike_energy":300}`, true, 2, time.Date(2024, 6, 1, 12, 2, 0, 0, time.UTC)},
+		// The counter is reset at midnight or after each report
+		{`{"lightning_strike_count":0,"lightning_strike_time":"2024-06-01T12:02:00+00:00"}`, false, 0, time.Time{}},
+		{`{"lightning_strike_count":1,"lightning_strike_time":"2024-06-01T12:02:00+00:00","lightning_strike_distance":6}`, true, 1, time.Date(2024, 6, 1, 12, 2, 0, 0, time.UTC)},
+		// A new strike time without counter
+		{`{"lightning_strike_time":"2024-06-01T12:05:00+00:00","lightning_strike_distance":4}`, true, 1, time.Date(2024, 6, 1, 12, 5, 0, 0, time.UTC)},
+		// A strike time not in RFC 3339 uses the observation time
+		{`{"lightning_strike_count":3,"lightning_strike_time":"soon"}`, true, 2, start.Add(7 * time.Minute)},
+		{`{"lightning_strike_count":3,"lightning_strike_time":"soon"}`, false, 0, time.Time{}},
+	} {
+		s, found := l.update(start.Add(time.Duration(i)*time.Minute), tt.payload)
+		if found != tt.found {
+			t.Errorf("step %d: found = %v, want %v", i, found, tt.found)
+			continue
+		}
+		if found && (s.Count != tt.count || !s.Time.Equal(tt.when)) {
+			t.Errorf("step %d: strike %+v, want %d strikes at %s", i, s, tt.count, tt.when)
+		}
+	}
+
+	strikes := l.log()
+	if len(strikes) != 4 || strikes[0].Count != 2 || strikes[3].Distance != 8 || strikes[3].Energy != 300 {
+		t.Errorf("strike log %+v", strikes)
+	}
+}
+
+func TestLightningTrackerLogSize(t *testing.T) {
+	l := &lightningTracker{}
+	start := time.Now()
+	for i := 0; i <= lightningLogSize+10; i++ {
+		l.update(start.Add(time.Duration(i)*time.Minute), fmt.Sprintf(`{"lightning_strike_count":%d}`, i))
+	}
+
+	strikes := l.log()
+	if len(strikes) != lightningLogSize || !strikes[0].Time.Equal(start.Add((lightningLogSize+10)*time.Minute)) {
+		t.Errorf("%d strikes kept, newest at %s", len(strikes), strikes[0].Time)
+	}
+}
+
+func TestLightningPanelRefreshLatest(t *testing.T) {
+	a := test.NewTempApp(t)
+	p := newLightningPanel(newUnitSettings(a.Preferences()))
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	p.refreshLatest(start)
+	if p.latest.Text != "No strike detected" || p.icon.Visible() {
+		t.Errorf("panel without strike shows %q", p.latest.Text)
+	}
+
+	p.update(start, `{"lightning_strike_count":0}`)
+	p.update(start.Add(time.Minute), `{"lightning_strike_count":1,"lightning_strike_time":"2024-06-01T12:01:00+00:00","lightning_strike_distance":5}`)
+	if !strings.HasSuffix(p.latest.Text, " 0s ago") || !p.icon.Visible() {
+		t.Errorf("panel after a strike shows %q", p.latest.Text)
+	}
+	if len(p.log()) != 1 || p.list.Length() != 1 {
+		t.Errorf("strike list has %d rows", p.list.Length())
+	}
+
+	// The age follows the ticker without new observations
+	p.refreshLatest(start.Add(11 * time.Minute))
+	if !strings.HasSuffix(p.latest.Text, " 10m0s ago") || !p.icon.Visible() {
+		t.Errorf("panel 10 minutes after a strike shows %q", p.latest.Text)
+	}
+	p.refreshLatest(start.Add(time.Hour))
+	if !strings.HasSuffix(p.latest.Text, " 59m0s ago") || p.icon.Visible() {
+		t.Errorf("panel an hour after a strike shows %q", p.latest.Text)
+	}
+}
@@ -1,17 +1,36 @@
 package main
 
 import (
+	"path/filepath"
+	"sync"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
 )
 
 type application struct {
-	app    fyne.App
-	window fyne.Window
+	app     fyne.App
+	window  fyne.Window
+	secrets secretStore
+	units   *unitSettings
+	store   *observationStore
+
+	conn      *brokerConnection
+	status    *connectionStatus
+	cardsLock sync.Mutex // cards are also read by the connection and freshness goroutines
+	cards     []*weatherCard
+	grid      *fyne.Container
 
-	card *weatherCard
+	action     *widget.Button
+	addStation *widget.Button
+	settings   *widget.Button
+	sections   *widget.Button
+	export     *widget.Button
+	alerts     *widget.Button
 }
 
 func main() {
@@ -24,13 +43,38 @@ func main() {
 	mLogo.FillMode = canvas.ImageFillContain
 	mLogo.SetMinSize(fyne.NewSize(275, 70))
 
-	weather := &application{app: a, window: w}
-	weather.card = weather.newWeatherCard()
+	weather := newApplication(a, w, newSecretStore(a), filepath.Join(a.Storage().RootURI().Path(), "observations"))
+	go weather.store.compact()
 
-	weather.window.SetContent(container.NewBorder(container.NewCenter(mLogo), nil, nil, nil, weather.card.makeWeatherCard()))
+	weather.window.SetContent(container.NewBorder(container.NewCenter(mLogo), nil, nil, nil, weather.makeDashboard()))
+	weather.restoreStations()
+	go weather.watchFreshness()
 
 	weather.connectionDialogShow()
 
-	weather.window.Resize(fyne.NewSize(450, 100))
+	weather.window.Resize(fyne.NewSize(450, 600))
 	weather.window.ShowAndRun()
 }
+
+// newApplication sets up the state and the buttons of the dashboard, storing
+// the observations in storeDir.
+func newApplication(a fyne.App, w fyne.Window, secrets secretStore, storeDir string) *application {
+	weather := &application{app: a, window: w, secrets: secrets, units: newUnitSettings(a.Preferences())}
+	weather.store = newObservationStore(storeDir, weather.storeRetention())
+
+	weather.conn = &brokerConnection{profile: weather.lastProfile()}
+	weather.status = newConnectionStatus()
+	weather.action = widget.NewButton("Connect", func() {
+		weather.stopMqtt(nil)
+
+		weather.connectionDialogShow()
+	})
+	weather.addStation = widget.NewButtonWithIcon("Add station", theme.ContentAddIcon(), weather.addStationShow)
+	weather.addStation.Disable()
+	weather.settings = widget.NewButtonWithIcon("Settings", theme.SettingsIcon(), weather.settingsDialogShow)
+	weather.sections = widget.NewButtonWithIcon("Sections", theme.ListIcon(), weather.sectionsDialogShow)
+	weather.export = widget.NewButtonWithIcon("Export…", theme.DocumentSaveIcon(), weather.exportDialogShow)
+	weather.alerts = widget.NewButtonWithIcon("Alerts", theme.WarningIcon(), weather.alertsDialogShow)
+
+	return weather
+}
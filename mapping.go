@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	presetWeatherflow2mqtt = "weatherflow2mqtt"
+	presetEcowitt          = "Ecowitt gateway"
+	presetRtl433           = "rtl_433"
+	presetWeeWX            = "WeeWX MQTT"
+	presetCustom           = "Custom"
+)
+
+// fieldMapping fills one observation field of the card from a value of the
+// station payload, converted with value * Scale + Offset then from Unit to
+// the unit published by weatherflow2mqtt.
+type fieldMapping struct {
+	Key    string  `json:"key"`
+	Path   string  `json:"path"` // dotted path, with [n] for array items
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+	Unit   string  `json:"unit,omitempty"`
+
+	// Topic and Format are used when every field has its own topic
+	Topic    string `json:"topic,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Template string `json:"template,omitempty"` // value template of formatTemplate
+}
+
+// stationMapping turns the payloads of a station into weatherflow2mqtt
+// observations, an empty mapping keeps them as they are.
+type stationMapping struct {
+	Name   string         `json:"name,omitempty"`   // shown instead of the serial when set
+	Origin string         `json:"origin,omitempty"` // how the station was found
+	Preset string         `json:"preset"`
+	Mode   string         `json:"mode,omitempty"`
+	Fields []fieldMapping `json:"fields,omitempty"`
+}
+
+const (
+	mappingPayload = ""       // one JSON document holds all the fields
+	mappingTopics  = "topics" // every field is published on its own topic
+
+	originHADiscovery = "homeassistant" // announced through Home Assistant discovery, without status topic
+)
+
+func mapped(key, path string, unit string) fieldMapping {
+	return fieldMapping{Key: key, Path: path, Scale: 1, Unit: unit}
+}
+
+var mappingPresets = map[string][]fieldMapping{
+	presetWeatherflow2mqtt: nil,
+	presetEcowitt: {
+		mapped("air_temperature", "tempf", "°F"),
+		mapped("feelslike", "feelslikef", "°F"),
+		mapped("dewpoint", "dewptf", "°F"),
+		mapped("relative_humidity", "humidity", ""),
+		mapped("sealevel_pressure", "baromrelin", "inHg"),
+		mapped("station_pressure", "baromabsin", "inHg"),
+		mapped("wind_speed", "windspeedmph", "mph"),
+		mapped("wind_gust", "windgustmph", "mph"),
+		mapped("wind_direction", "winddir", ""),
+		mapped("uv", "uv", ""),
+		mapped("solar_radiation", "solarradiation", ""),
+		mapped("rain_rate", "rainratein", "in"),
+		mapped("rain_today", "dailyrainin", "in"),
+		mapped("lightning_strike_distance", "lightning", ""),
+		mapped("lightning_strike_count", "lightning_num", ""),
+	},
+	presetRtl433: {
+		mapped("air_temperature", "temperature_C", ""),
+		mapped("relative_humidity", "humidity", ""),
+		mapped("station_pressure", "pressure_hPa", ""),
+		mapped("wind_speed", "wind_avg_km_h", ""),
+		mapped("wind_gust", "wind_max_km_h", ""),
+		mapped("wind_direction", "wind_dir_deg", ""),
+		mapped("uv", "uvi", ""),
+		mapped("illuminance", "light_lux", ""),
+		// rain_mm is the total since the sensor started, not the rain of the day
+		{Key: "battery_level", Path: "battery_ok", Scale: 100}, // 0 or 1
+	},
+	presetWeeWX: {
+		mapped("air_temperature", "outTemp_F", "°F"),
+		mapped("feelslike", "appTemp_F", "°F"),
+		mapped("dewpoint", "dewpoint_F", "°F"),
+		mapped("relative_humidity", "outHumidity", ""),
+		mapped("sealevel_pressure", "barometer_inHg", "inHg"),
+		mapped("station_pressure", "pressure_inHg", "inHg"),
+		mapped("wind_speed", "windSpeed_mph", "mph"),
+		mapped("wind_gust", "windGust_mph", "mph"),
+		mapped("wind_direction", "windDir", ""),
+		mapped("uv", "UV", ""),
+		mapped("solar_radiation", "radiation_Wpm2", ""),
+		mapped("rain_rate", "rainRate_inch_per_hour", "in"),
+		mapped("rain_today", "dayRain_in", "in"),
+	},
+}
+
+func presetNames() []string {
+	return []string{presetWeatherflow2mqtt, presetEcowitt, presetRtl433, presetWeeWX, presetCustom}
+}
+
+// mappingKeys lists the observation fields the card can show.
+func mappingKeys() []string {
+	keys := []string{"sealevel_pressure", "station_pressure", "pressure_trend", "pressure_trend_value", "wind_bearing_avg"}
+	for _, section := range cardSections {
+		for _, field := range section.fields {
+			keys = append(keys, field.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// numericKeys are the observation fields read as numbers by the card.
+var numericKeys = func() map[string]bool {
+	keys := map[string]bool{"sealevel_pressure": true, "station_pressure": true, "pressure_trend_value": true, "wind_bearing_avg": true}
+	for _, section := range cardSections {
+		for _, field := range section.fields {
+			if field.format != "" {
+				keys[field.key] = true
+			}
+		}
+	}
+	return keys
+}()
+
+// toPublishedUnit converts a value to the unit published by weatherflow2mqtt.
+func toPublishedUnit(v float64, unit string) float64 {
+	switch unit {
+	case "°F":
+		return (v - 32) * 5 / 9
+	case "mph", "mi":
+		return v * 1.609344
+	case "m/s":
+		return v * 3.6
+	case "kn":
+		return v * 1.852
+	case "inHg":
+		return v / 0.0295299830714
+	case "mmHg":
+		return v / 0.750061683
+	case "in":
+		return v * 25.4
+	}
+	return v
+}
+
+// lookupPath finds the value at a dotted path like "model.sensors[0].temp".
+func lookupPath(v interface{}, path string) (interface{}, bool) {
+	for _, part := range strings.Split(path, ".") {
+		name, index, indexed := strings.Cut(part, "[")
+		if name != "" {
+			object, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = object[name]; !ok {
+				return nil, false
+			}
+		}
+
+		for indexed {
+			var rest string
+			index, rest, _ = strings.Cut(index, "]")
+			i, err := strconv.Atoi(index)
+			array, ok := v.([]interface{})
+			if err != nil || !ok || i < 0 || i >= len(array) {
+				return nil, false
+			}
+			v = array[i]
+			_, index, indexed = strings.Cut(rest, "[")
+		}
+	}
+	return v, true
+}
+
+// convert scales a number and converts it to the published unit, other values
+// are kept as they are. Numbers published as text, as WeeWX does, are parsed
+// when the field is converted or shown as a number.
+func (f fieldMapping) convert(v interface{}) interface{} {
+	number, isNumber := v.(float64)
+	if s, isString := v.(string); isString {
+		if parsed, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && (f.Scale != 1 || f.Offset != 0 || f.Unit != "" || numericKeys[f.Key]) {
+			number, isNumber = parsed, true
+		}
+	}
+	if !isNumber {
+		return v
+	}
+	return toPublishedUnit(number*f.Scale+f.Offset, f.Unit)
+}
+
+// apply returns the weatherflow2mqtt observation built from a payload.
+func (m stationMapping) apply(payload string) (string, error) {
+	if len(m.Fields) == 0 {
+		return payload, nil
+	}
+
+	var source interface{}
+	if err := json.Unmarshal([]byte(payload), &source); err != nil {
+		return "", err
+	}
+
+	observation := map[string]interface{}{}
+	for _, f := range m.Fields {
+		if v, ok := lookupPath(source, f.Path); ok {
+			observation[f.Key] = f.convert(v)
+		}
+	}
+
+	data, err := json.Marshal(observation)
+	return string(data), err
+}
+
+func stationMappingKey(serial string) string {
+	return "fieldMapping-" + serial
+}
+
+func (app *application) loadStationMapping(serial string) stationMapping {
+	m := stationMapping{Preset: presetWeatherflow2mqtt}
+	if saved := app.app.Preferences().String(stationMappingKey(serial)); saved != "" {
+		if err := json.Unmarshal([]byte(saved), &m); err != nil {
+			fyne.LogError("Unable to read the field mapping of "+serial, err)
+		}
+	}
+	return m
+}
+
+func (app *application) saveStationMapping(serial string, m stationMapping) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		fyne.LogError("Unable to save the field mapping of "+serial, err)
+		return
+	}
+	app.app.Preferences().SetString(stationMappingKey(serial), string(data))
+}
+
+// mapper follows a raw MQTT binding and exposes its payloads translated by
+// the mapping of the station, which can change while bound.
+type mapper struct {
+	lock    sync.RWMutex
+	mapping stationMapping
+}
+
+func (m *mapper) get() stationMapping {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.mapping
+}
+
+func (m *mapper) set(mapping stationMapping) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.mapping = mapping
+}
+
+func (m *mapper) bind(raw binding.String) binding.String {
+	observation := binding.NewString()
+
+	raw.AddListener(binding.NewDataListener(func() {
+		payload, err := raw.Get()
+		if err != nil || payload == "" {
+			return
+		}
+
+		translated, err := m.get().apply(payload)
+		if err != nil {
+			fyne.LogError("Unable to map the observation", err)
+			return
+		}
+		observation.Set(translated)
+	}))
+
+	return observation
+}
+
+// fieldMappingRow edits one field of the mapping dialog.
+type fieldMappingRow struct {
+	key, unit, format          *widget.Select
+	topic, path, scale, offset *widget.Entry
+	topicRow                   fyne.CanvasObject
+	content                    fyne.CanvasObject
+}
+
+func newFieldMappingRow(f fieldMapping, remove func(*fieldMappingRow)) *fieldMappingRow {
+	number := func(v float64, placeholder string) *widget.Entry {
+		e := widget.NewEntry()
+		e.SetPlaceHolder(placeholder)
+		e.SetText(strconv.FormatFloat(v, 'f', -1, 64))
+		e.Validator = func(text string) error {
+			_, err := strconv.ParseFloat(text, 64)
+			return err
+		}
+		return e
+	}
+
+	row := &fieldMappingRow{
+		key:    widget.NewSelect(mappingKeys(), nil),
+		unit:   widget.NewSelect([]string{"", "°F", "mph", "m/s", "kn", "inHg", "mmHg", "in", "mi"}, nil),
+		path:   widget.NewEntry(),
+		scale:  number(f.Scale, "Scale"),
+		offset: number(f.Offset, "Offset"),
+	}
+	row.key.SetSelected(f.Key)
+	row.unit.PlaceHolder = "Published unit"
+	row.unit.SetSelected(f.Unit)
+	row.path.SetPlaceHolder("JSON path")
+	row.path.SetText(f.Path)
+	if f.Format == formatTemplate {
+		row.path.SetText(f.Template)
+	}
+
+	row.topic = widget.NewEntry()
+	row.topic.SetPlaceHolder("weather/{serial}/temperature/state")
+	row.topic.SetText(f.Topic)
+	row.topic.Validator = validateFieldTopic
+	row.format = widget.NewSelect([]string{formatNumber, formatString, formatJSON, formatTemplate}, func(format string) {
+		switch format {
+		case formatJSON:
+			row.path.SetPlaceHolder("JSON path")
+			row.path.Enable()
+		case formatTemplate:
+			row.path.SetPlaceHolder("{{ value_json.temperature | float }}")
+			row.path.Enable()
+		default:
+			row.path.Disable()
+		}
+	})
+	row.topicRow = container.NewBorder(nil, nil, nil, row.format, row.topic)
+
+	deleteButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() { remove(row) })
+	row.content = container.NewBorder(nil, nil, nil, deleteButton, container.NewVBox(
+		row.topicRow,
+		container.NewGridWithColumns(2, row.key, row.path),
+		container.NewGridWithColumns(3, row.scale, row.offset, row.unit)))
+
+	return row
+}
+
+func (row *fieldMappingRow) field() (fieldMapping, error) {
+	scale, err := strconv.ParseFloat(row.scale.Text, 64)
+	if err != nil {
+		return fieldMapping{}, fmt.Errorf("invalid scale for %s: %w", row.key.Selected, err)
+	}
+	offset, err := strconv.ParseFloat(row.offset.Text, 64)
+	if err != nil {
+		return fieldMapping{}, fmt.Errorf("invalid offset for %s: %w", row.key.Selected, err)
+	}
+
+	f := fieldMapping{Key: row.key.Selected, Path: row.path.Text, Scale: scale, Offset: offset, Unit: row.unit.Selected,
+		Topic: row.topic.Text, Format: row.format.Selected}
+	if f.Format == formatTemplate {
+		f.Path, f.Template = "", row.path.Text
+		if _, err := compileValueTemplate(f.Template); err != nil {
+			return fieldMapping{}, fmt.Errorf("invalid template for %s: %w", row.key.Selected, err)
+		}
+	}
+	return f, nil
+}
+
+// setMode shows the topic of the field only when every field has its own topic.
+func (row *fieldMappingRow) setMode(mode string) {
+	if mode == mappingTopics {
+		row.topicRow.Show()
+		if row.format.Selected == "" {
+			row.format.SetSelected(formatNumber)
+		}
+		row.format.OnChanged(row.format.Selected)
+	} else {
+		row.topicRow.Hide()
+		row.path.SetPlaceHolder("JSON path")
+		row.path.Enable()
+	}
+}
+
+// fieldMappingDialogShow edits how the payloads of the station of a card are read.
+func (app *application) fieldMappingDialogShow(card *weatherCard) {
+	rows := []*fieldMappingRow{}
+	list := container.NewVBox()
+
+	current := card.mapper.get()
+	mode := widget.NewRadioGroup([]string{"One JSON payload", "One topic per field"}, nil)
+	mode.Horizontal = true
+	modeName := func() string {
+		if mode.Selected == mode.Options[1] {
+			return mappingTopics
+		}
+		return mappingPayload
+	}
+
+	var remove func(*fieldMappingRow)
+	add := func(f fieldMapping) {
+		row := newFieldMappingRow(f, remove)
+		row.setMode(modeName())
+		rows = append(rows, row)
+		list.Add(row.content)
+	}
+	remove = func(row *fieldMappingRow) {
+		for i, r := range rows {
+			if r == row {
+				rows = append(rows[:i], rows[i+1:]...)
+				break
+			}
+		}
+		list.Remove(row.content)
+	}
+	fill := func(fields []fieldMapping) {
+		rows = nil
+		list.RemoveAll()
+		for _, f := range fields {
+			add(f)
+		}
+	}
+
+	if current.Mode == mappingTopics {
+		mode.SetSelected(mode.Options[1])
+	} else {
+		mode.SetSelected(mode.Options[0])
+	}
+	fill(current.Fields)
+
+	preset := widget.NewSelect(presetNames(), nil)
+	preset.SetSelected(current.Preset)
+	preset.OnChanged = func(name string) {
+		if fields, ok := mappingPresets[name]; ok {
+			fill(fields)
+		}
+	}
+
+	addField := widget.NewButtonWithIcon("Add field", theme.ContentAddIcon(), func() {
+		add(fieldMapping{Key: "air_temperature", Scale: 1})
+	})
+	hint := widget.NewLabel("Without any field, the payload is read as weatherflow2mqtt publishes it.")
+	hint.Wrapping = fyne.TextWrapWord
+
+	mode.OnChanged = func(string) {
+		for _, row := range rows {
+			row.setMode(modeName())
+		}
+		if modeName() == mappingTopics {
+			preset.Disable()
+			hint.SetText("Every field is read from its own topic, {serial} is the station serial.")
+		} else {
+			preset.Enable()
+			hint.SetText("Without any field, the payload is read as weatherflow2mqtt publishes it.")
+		}
+	}
+	mode.OnChanged(mode.Selected)
+
+	d := dialog.NewCustomConfirm("Field mapping of "+stationTitle(card.serial), "Apply", "Cancel",
+		container.NewBorder(container.NewVBox(mode, preset, hint), addField, nil, nil, container.NewVScroll(list)), func(ok bool) {
+			if !ok {
+				return
+			}
+
+			m := stationMapping{Name: current.Name, Origin: current.Origin, Preset: preset.Selected, Mode: modeName()}
+			for _, row := range rows {
+				f, err := row.field()
+				if err == nil && m.Mode == mappingTopics {
+					err = validateFieldTopic(f.Topic)
+				}
+				if err != nil {
+					dialog.ShowError(err, app.window)
+					return
+				}
+				if m.Mode == mappingPayload {
+					f.Topic, f.Format = "", ""
+				}
+				m.Fields = append(m.Fields, f)
+			}
+			if fields, ok := mappingPresets[m.Preset]; !ok || !reflect.DeepEqual(fields, m.Fields) {
+				m.Preset = presetCustom
+			}
+
+			resubscribe := m.Mode == mappingTopics || current.Mode == mappingTopics
+			card.mapper.set(m)
+			app.saveStationMapping(card.serial, m)
+
+			// The subscriptions of the card depend on the mode and on the field topics
+			if resubscribe && app.conn.session() != nil {
+				card.disconnect()
+				if err := app.bindCard(card, func() {}); err != nil {
+					dialog.ShowError(err, app.window)
+				}
+			}
+		}, app.window)
+	d.Resize(fyne.NewSize(500, 500))
+	d.Show()
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestLookupPath(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"a":1,"model":{"name":"WS","sensors":[{"temp":20.5},{"temp":[1,[2,3]]}]},"list":["x","y"]}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path  string
+		want  interface{}
+		found bool
+	}{
+		{"a", 1.0, true},
+		{"model.name", "WS", true},
+		{"model.sensors[0].temp", 20.5, true},
+		{"model.sensors[1].temp[1][0]", 2.0, true},
+		{"list[1]", "y", true},
+		{"missing", nil, false},
+		{"a.b", nil, false},
+		{"model.sensors[2].temp", nil, false},
+		{"model.sensors[-1]", nil, false},
+		{"model.sensors[x]", nil, false},
+		{"list.name", nil, false},
+		{"model[0]", nil, false},
+	} {
+		v, found := lookupPath(doc, tt.path)
+		if found != tt.found || (found && v != tt.want) {
+			t.Errorf("lookupPath(%q) = %v, %v, want %v, %v", tt.path, v, found, tt.want, tt.found)
+		}
+	}
+}
+
+func TestToPublishedUnit(t *testing.T) {
+	for _, tt := range []struct {
+		v    float64
+		unit string
+		want float64
+	}{
+		{68, "°F", 20},
+		{-40, "°F", -40},
+		{10, "mph", 16.09344},
+		{1, "mi", 1.609344},
+		{10, "m/s", 36},
+		{10, "kn", 18.52},
+		{29.92, "inHg", 1013.21},
+		{760, "mmHg", 1013.25},
+		{1, "in", 25.4},
+		{42, "", 42},
+		{42, "hPa", 42},
+	} {
+		if got := toPublishedUnit(tt.v, tt.unit); math.Abs(got-tt.want) > 0.01 {
+			t.Errorf("toPublishedUnit(%g, %q) = %g, want %g", tt.v, tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestPresetsApply(t *testing.T) {
+	for _, tt := range []struct {
+		preset  string
+		payload string
+		want    map[string]interface{}
+	}{
+		{presetWeatherflow2mqtt, `{"air_temperature":21.5}`, map[string]interface{}{"air_temperature": 21.5}},
+		{presetEcowitt,
+			`{"tempf":"68.0","humidity":55,"baromrelin":29.92,"baromabsin":29.5,"windspeedmph":10,"windgustmph":15,"winddir":270,"uv":3,"solarradiation":450.5,"rainratein":0.1,"dailyrainin":1,"lightning":12,"lightning_num":4,"model":"GW1100"}`,
+			map[string]interface{}{"air_temperature": 20.0, "relative_humidity": 55.0, "sealevel_pressure": 1013.21, "station_pressure": 998.98,
+				"wind_speed": 16.09, "wind_gust": 24.14, "wind_direction": 270.0, "uv": 3.0, "solar_radiation": 450.5,
+				"rain_rate": 2.54, "rain_today": 25.4, "lightning_strike_distance": 12.0, "lightning_strike_count": 4.0}},
+		{presetRtl433,
+			`{"time":"2024-06-01 12:00:00","model":"Fineoffset-WH65B","id":60,"battery_ok":1,"temperature_C":21.3,"humidity":55,"wind_dir_deg":270,"wind_avg_km_h":10.8,"wind_max_km_h":14.4,"rain_mm":12.5,"light_lux":12000,"uvi":3}`,
+			map[string]interface{}{"air_temperature": 21.3, "relative_humidity": 55.0, "wind_speed": 10.8, "wind_gust": 14.4, "wind_direction": 270.0,
+				"uv": 3.0, "illuminance": 12000.0, "battery_level": 100.0}},
+		{presetWeeWX,
+			`{"dateTime":"1717243200.0","outTemp_F":"68.0","appTemp_F":"50.0","dewpoint_F":"32.0","outHumidity":"55.0","barometer_inHg":"29.92","pressure_inHg":"29.5","windSpeed_mph":"10.0","windGust_mph":"15.0","windDir":"270.0","UV":"3.0","radiation_Wpm2":"450.0","rainRate_inch_per_hour":"0.1","dayRain_in":"1.0"}`,
+			map[string]interface{}{"air_temperature": 20.0, "feelslike": 10.0, "dewpoint": 0.0, "relative_humidity": 55.0,
+				"sealevel_pressure": 1013.21, "station_pressure": 998.98, "wind_speed": 16.09, "wind_gust": 24.14, "wind_direction": 270.0,
+				"uv": 3.0, "solar_radiation": 450.0, "rain_rate": 2.54, "rain_today": 25.4}},
+	} {
+		m := stationMapping{Preset: tt.preset, Fields: mappingPresets[tt.preset]}
+		out, err := m.apply(tt.payload)
+		if err != nil {
+			t.Errorf("%s: %v", tt.preset, err)
+			continue
+		}
+
+		var observation map[string]interface{}
+		if err := json.Unmarshal([]byte(out), &observation); err != nil {
+			t.Fatalf("%s: invalid observation %s", tt.preset, out)
+		}
+		if len(observation) != len(tt.want) {
+			t.Errorf("%s: observation %v has %d fields, want %d", tt.preset, observation, len(observation), len(tt.want))
+		}
+		for key, want := range tt.want {
+			got, ok := observation[key].(float64)
+			if !ok || math.Abs(got-want.(float64)) > 0.01 {
+				t.Errorf("%s: %s = %v, want %v", tt.preset, key, observation[key], want)
+			}
+		}
+	}
+}
+
+func TestFieldMappingConvert(t *testing.T) {
+	for _, tt := range []struct {
+		mapping fieldMapping
+		v, want interface{}
+	}{
+		{mapped("air_temperature", "t", ""), 21.5, 21.5},
+		{mapped("air_temperature", "t", ""), "21.5", 21.5},
+		{mapped("sealevel_pressure", "p", ""), "1013.2", 1013.2},
+		{mapped("station_pressure", "p", ""), "998.9", 998.9},
+		{mapped("pressure_trend_value", "p", ""), "-0.4", -0.4},
+		{mapped("wind_bearing_avg", "w", ""), "270", 270.0},
+		{mapped("pressure_trend", "p", ""), "1.5", "1.5"},
+		{mapped("air_temperature", "t", "°F"), " 68 ", 20.0},
+		{fieldMapping{Key: "air_temperature", Scale: 0.1, Offset: -40}, 615.0, 21.5},
+		{mapped("precipitation_type", "p", ""), "Rain", "Rain"},
+		{mapped("air_temperature", "t", ""), true, true},
+	} {
+		if got := tt.mapping.convert(tt.v); got != tt.want {
+			if f, ok := got.(float64); !ok || math.Abs(f-tt.want.(float64)) > 1e-9 {
+				t.Errorf("%+v convert(%v) = %v, want %v", tt.mapping, tt.v, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/validation"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/google/uuid"
+)
+
+var (
+	profilesKey    = "connectionProfiles"
+	lastProfileKey = "lastConnectionProfile"
+
+	defaultTopicPrefix = "homeassistant"
+)
+
+type connectionProfile struct {
+	Name        string         `json:"name"`
+	Broker      string         `json:"broker"`
+	User        string         `json:"user,omitempty"`
+	TLS         tlsSettings    `json:"tls"`
+	TopicPrefix string         `json:"topicPrefix,omitempty"`
+	Topics      topicTemplates `json:"topics"`
+	QoS         byte           `json:"qos"`
+	ClientID    string         `json:"clientID,omitempty"`
+
+	RememberPassword bool `json:"rememberPassword,omitempty"`
+
+	Protocol       string            `json:"protocol,omitempty"`
+	SessionExpiry  uint32            `json:"sessionExpiry,omitempty"`
+	UserProperties map[string]string `json:"userProperties,omitempty"`
+
+	Stations []string `json:"stations,omitempty"`
+}
+
+func newConnectionProfile(name string) connectionProfile {
+	return connectionProfile{Name: name, TopicPrefix: defaultTopicPrefix, QoS: 1}
+}
+
+// clientID returns the configured MQTT client identifier or a random one.
+func (p connectionProfile) clientID() string {
+	if p.ClientID != "" {
+		return p.ClientID
+	}
+	return "FyneLabs.weather." + uuid.NewString()
+}
+
+// parseUserProperties reads MQTT 5 user properties written as "key=value, key=value".
+func parseUserProperties(s string) map[string]string {
+	props := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		k, v, _ := strings.Cut(pair, "=")
+		if k = strings.TrimSpace(k); k != "" {
+			props[k] = strings.TrimSpace(v)
+		}
+	}
+
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
+
+func formatUserProperties(props map[string]string) string {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + props[k]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (p connectionProfile) topicPrefix() string {
+	if p.TopicPrefix != "" {
+		return p.TopicPrefix
+	}
+	return defaultTopicPrefix
+}
+
+// loadProfiles reads the saved connection profiles, migrating the single broker
+// preference used by earlier versions when no profile exists yet.
+func (app *application) loadProfiles() []connectionProfile {
+	var profiles []connectionProfile
+
+	if s := app.app.Preferences().String(profilesKey); s != "" {
+		if err := json.Unmarshal([]byte(s), &profiles); err != nil {
+			fyne.LogError("Unable to read connection profiles", err)
+		}
+	}
+
+	if len(profiles) == 0 {
+		p := newConnectionProfile("Default")
+		p.Broker = app.app.Preferences().String(mqttBrokerKey)
+		profiles = append(profiles, p)
+	}
+
+	return profiles
+}
+
+// lastProfile returns the profile used for the last successful connection.
+func (app *application) lastProfile() connectionProfile {
+	profiles := app.loadProfiles()
+
+	last := app.app.Preferences().String(lastProfileKey)
+	for _, p := range profiles {
+		if p.Name == last {
+			return p
+		}
+	}
+	return profiles[0]
+}
+
+func (app *application) saveProfiles(profiles []connectionProfile) {
+	b, err := json.Marshal(profiles)
+	if err != nil {
+		fyne.LogError("Unable to save connection profiles", err)
+		return
+	}
+
+	app.app.Preferences().SetString(profilesKey, string(b))
+}
+
+// profileEditor holds the widgets of the connection dialog used to select and
+// edit the saved connection profiles.
+type profileEditor struct {
+	app      *application
+	profiles []connectionProfile
+	current  int
+
+	picker   *widget.Select
+	name     *widget.Entry
+	broker   *widget.Entry
+	user     *widget.Entry
+	password *widget.Entry
+	remember *widget.Check
+	ca       *widget.Entry
+	cert     *widget.Entry
+	key      *widget.Entry
+	insecure *widget.Check
+	prefix   *widget.Entry
+	topics   [4]*widget.Entry // discovery, serial pattern, observation and status
+	preview  *widget.Label
+	qos      *widget.Select
+	clientID *widget.Entry
+	protocol *widget.Select
+	expiry   *widget.Entry
+	props    *widget.Entry
+
+	caRow, certRow, keyRow fyne.CanvasObject
+}
+
+func (app *application) newProfileEditor() *profileEditor {
+	e := &profileEditor{app: app, profiles: app.loadProfiles()}
+
+	e.name = widget.NewEntry()
+	e.name.SetPlaceHolder("Home broker")
+
+	e.broker = widget.NewEntry()
+	e.broker.SetPlaceHolder("tcp://broker.emqx.io:1883/")
+	e.broker.Validator = validation.NewRegexp(`(tcp|ssl|tls|ws|wss)://[a-z0-9-._-]+:\d+/`, "not a valid broker address")
+
+	e.user = widget.NewEntry()
+	e.user.SetPlaceHolder("anonymous")
+
+	e.password = widget.NewPasswordEntry()
+	e.password.SetPlaceHolder("")
+	e.remember = widget.NewCheck("Remember password", nil)
+
+	e.ca, e.caRow = app.fileEntry("System certificates")
+	e.cert, e.certRow = app.fileEntry("No client certificate")
+	e.key, e.keyRow = app.fileEntry("No client key")
+	e.insecure = widget.NewCheck("Skip certificate verification", nil)
+
+	e.prefix = widget.NewEntry()
+	e.prefix.SetPlaceHolder(defaultTopicPrefix)
+
+	for i, placeholder := range []string{defaultDiscoveryTopic, defaultSerialPattern, defaultObservationTopic, defaultStatusTopic} {
+		e.topics[i] = widget.NewEntry()
+		e.topics[i].SetPlaceHolder(placeholder)
+	}
+	e.topics[0].Validator = func(s string) error { return validateTopicFilter(orDefault(s, defaultDiscoveryTopic)) }
+	e.topics[1].Validator = func(s string) error {
+		_, err := compileSerialPattern(orDefault(s, defaultSerialPattern))
+		return err
+	}
+	e.topics[2].Validator = func(s string) error { return validateTopicTemplate(orDefault(s, defaultObservationTopic)) }
+	e.topics[3].Validator = func(s string) error { return validateTopicTemplate(orDefault(s, defaultStatusTopic)) }
+
+	e.preview = widget.NewLabel("")
+	e.preview.Wrapping = fyne.TextWrapBreak
+	refresh := func(string) { e.preview.SetText(e.selected().preview()) }
+	e.prefix.OnChanged = refresh
+	for _, entry := range e.topics {
+		entry.OnChanged = refresh
+	}
+
+	e.qos = widget.NewSelect([]string{"0", "1", "2"}, nil)
+
+	e.clientID = widget.NewEntry()
+	e.clientID.SetPlaceHolder("Random")
+
+	e.protocol = widget.NewSelect([]string{protocolV311, protocolV5}, nil)
+
+	e.expiry = widget.NewEntry()
+	e.expiry.SetPlaceHolder("0")
+	e.expiry.Validator = validation.NewRegexp(`^\d*$`, "not a number of seconds")
+
+	e.props = widget.NewEntry()
+	e.props.SetPlaceHolder("site=home, app=weather")
+
+	e.picker = widget.NewSelect(nil, func(name string) {
+		for i, p := range e.profiles {
+			if p.Name == name && i != e.current {
+				e.show(i)
+				return
+			}
+		}
+	})
+
+	last := app.app.Preferences().String(lastProfileKey)
+	for i, p := range e.profiles {
+		if p.Name == last {
+			e.current = i
+		}
+	}
+	e.show(e.current)
+
+	return e
+}
+
+func (e *profileEditor) formItems() []*widget.FormItem {
+	actions := container.NewHBox(
+		widget.NewButtonWithIcon("", theme.DocumentSaveIcon(), e.save),
+		widget.NewButtonWithIcon("", theme.ContentAddIcon(), e.add),
+		widget.NewButtonWithIcon("", theme.ContentCopyIcon(), e.duplicate),
+		widget.NewButtonWithIcon("", theme.DeleteIcon(), e.remove),
+	)
+
+	return []*widget.FormItem{
+		{Text: "Profile", Widget: container.NewBorder(nil, nil, nil, actions, e.picker), HintText: "Save, add, duplicate or delete connection profiles"},
+		{Text: "Name", Widget: e.name},
+		{Text: "Broker", Widget: e.broker, HintText: "MQTT broker to connect to"},
+		{Text: "User", Widget: e.user, HintText: "User to use for connecting (optional)"},
+		{Text: "Password", Widget: e.password, HintText: "User password to use for connecting (optional)"},
+		{Text: "", Widget: e.remember, HintText: "Keep the password in the system keyring"},
+		{Text: "CA", Widget: e.caRow, HintText: "PEM bundle to verify ssl://, tls:// and wss:// brokers (optional)"},
+		{Text: "Certificate", Widget: e.certRow, HintText: "PEM client certificate for mutual TLS (optional)"},
+		{Text: "Key", Widget: e.keyRow, HintText: "PEM private key of the client certificate (optional)"},
+		{Text: "", Widget: e.insecure, HintText: "Only for lab brokers with self-signed certificates"},
+		{Text: "Topic prefix", Widget: e.prefix, HintText: "Home Assistant discovery prefix"},
+		{Text: "Discovery topic", Widget: e.topics[0], HintText: "Subscription finding the stations, {prefix} is the topic prefix"},
+		{Text: "Serial pattern", Widget: e.topics[1], HintText: "Regexp of whole topic levels capturing the serial"},
+		{Text: "Observation topic", Widget: e.topics[2], HintText: "Topic of the observations, {serial} is the station serial"},
+		{Text: "Status topic", Widget: e.topics[3], HintText: "Topic of the station status"},
+		{Text: "Resolved topics", Widget: e.preview},
+		{Text: "QoS", Widget: e.qos, HintText: "Quality of service of the discovery subscription"},
+		{Text: "Client ID", Widget: e.clientID, HintText: "MQTT client identifier (optional)"},
+		{Text: "MQTT version", Widget: e.protocol, HintText: "Protocol version spoken with the broker"},
+		{Text: "Session expiry", Widget: e.expiry, HintText: "MQTT 5 session expiry in seconds, 0 for a clean session"},
+		{Text: "User properties", Widget: e.props, HintText: "MQTT 5 user properties sent when connecting (optional)"},
+	}
+}
+
+// show fills the form with the profile at index i.
+func (e *profileEditor) show(i int) {
+	e.current = i
+	p := e.profiles[i]
+
+	e.name.SetText(p.Name)
+	e.broker.SetText(p.Broker)
+	e.user.SetText(p.User)
+	e.password.SetText("")
+	e.remember.SetChecked(p.RememberPassword)
+	if p.RememberPassword {
+		if secret, err := e.app.secrets.Get(p.Name); err == nil {
+			e.password.SetText(secret)
+		} else if err != errSecretNotFound {
+			fyne.LogError("Unable to read the password of "+p.Name, err)
+		}
+	}
+	e.ca.SetText(p.TLS.CA)
+	e.cert.SetText(p.TLS.Cert)
+	e.key.SetText(p.TLS.Key)
+	e.insecure.SetChecked(p.TLS.Insecure)
+	e.prefix.SetText(p.TopicPrefix)
+	for i, template := range []string{p.Topics.Discovery, p.Topics.SerialPattern, p.Topics.Observation, p.Topics.Status} {
+		e.topics[i].SetText(template)
+	}
+	e.qos.SetSelected(strconv.Itoa(int(p.QoS)))
+	e.clientID.SetText(p.ClientID)
+	if p.Protocol == "" {
+		e.protocol.SetSelected(protocolV311)
+	} else {
+		e.protocol.SetSelected(p.Protocol)
+	}
+	e.expiry.SetText("")
+	if p.SessionExpiry != 0 {
+		e.expiry.SetText(strconv.FormatUint(uint64(p.SessionExpiry), 10))
+	}
+	e.props.SetText(formatUserProperties(p.UserProperties))
+	e.preview.SetText(p.preview())
+
+	e.refreshPicker()
+}
+
+func (e *profileEditor) refreshPicker() {
+	names := make([]string, len(e.profiles))
+	for i, p := range e.profiles {
+		names[i] = p.Name
+	}
+	e.picker.Options = names
+	e.picker.SetSelected(e.profiles[e.current].Name)
+}
+
+// selected returns the profile as currently shown in the form.
+func (e *profileEditor) selected() connectionProfile {
+	qos, _ := strconv.Atoi(e.qos.Selected)
+	expiry, _ := strconv.ParseUint(e.expiry.Text, 10, 32)
+
+	return connectionProfile{
+		Name:        e.uniqueName(e.name.Text, e.current),
+		Broker:      e.broker.Text,
+		User:        e.user.Text,
+		TLS:         tlsSettings{CA: e.ca.Text, Cert: e.cert.Text, Key: e.key.Text, Insecure: e.insecure.Checked},
+		TopicPrefix: e.prefix.Text,
+		Topics: topicTemplates{Discovery: e.topics[0].Text, SerialPattern: e.topics[1].Text,
+			Observation: e.topics[2].Text, Status: e.topics[3].Text},
+		QoS:      byte(qos),
+		ClientID: e.clientID.Text,
+
+		RememberPassword: e.remember.Checked,
+
+		Protocol:       e.protocol.Selected,
+		SessionExpiry:  uint32(expiry),
+		UserProperties: parseUserProperties(e.props.Text),
+
+		Stations: e.profiles[e.current].Stations,
+	}
+}
+
+// uniqueName makes sure no other profile than the one at index skip uses name.
+func (e *profileEditor) uniqueName(name string, skip int) string {
+	if name == "" {
+		name = "Unnamed"
+	}
+
+	candidate := name
+	for n := 2; ; n++ {
+		taken := false
+		for i, p := range e.profiles {
+			if i != skip && p.Name == candidate {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, n)
+	}
+}
+
+func (e *profileEditor) save() {
+	old := e.profiles[e.current].Name
+	p := e.selected()
+
+	e.profiles[e.current] = p
+	e.app.saveProfiles(e.profiles)
+
+	if old != p.Name || !p.RememberPassword || e.password.Text == "" {
+		e.app.secrets.Delete(old)
+	}
+	if p.RememberPassword && e.password.Text != "" {
+		if err := e.app.secrets.Set(p.Name, e.password.Text); err != nil {
+			fyne.LogError("Unable to remember the password of "+p.Name, err)
+		}
+	}
+
+	e.name.SetText(e.profiles[e.current].Name)
+	e.refreshPicker()
+}
+
+func (e *profileEditor) add() {
+	e.profiles = append(e.profiles, newConnectionProfile(e.uniqueName("New profile", -1)))
+	e.show(len(e.profiles) - 1)
+}
+
+// duplicate adds a copy of the profile shown, keeping its password.
+func (e *profileEditor) duplicate() {
+	p := e.selected()
+	p.Name = e.uniqueName(p.Name+" copy", -1)
+	password := e.password.Text
+
+	if p.RememberPassword && password != "" {
+		if err := e.app.secrets.Set(p.Name, password); err != nil {
+			fyne.LogError("Unable to remember the password of "+p.Name, err)
+		}
+	}
+
+	e.profiles = append(e.profiles, p)
+	e.show(len(e.profiles) - 1)
+	e.password.SetText(password)
+}
+
+func (e *profileEditor) remove() {
+	name := e.profiles[e.current].Name
+
+	dialog.ShowConfirm("Delete profile", "Delete the connection profile "+name+"?", func(ok bool) {
+		if !ok {
+			return
+		}
+
+		e.app.secrets.Delete(name)
+		e.profiles = append(e.profiles[:e.current], e.profiles[e.current+1:]...)
+		if len(e.profiles) == 0 {
+			e.profiles = append(e.profiles, newConnectionProfile("Default"))
+		}
+		e.app.saveProfiles(e.profiles)
+
+		e.show(max(0, e.current-1))
+	}, e.app.window)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"fyne.io/fyne/v2/test"
+)
+
+func TestProfileEditorSecrets(t *testing.T) {
+	secrets := newMemoryStore()
+	app := &application{app: test.NewTempApp(t), secrets: secrets}
+	e := app.newProfileEditor()
+
+	// Saving remembers the password under the profile name
+	e.name.SetText("Home")
+	e.password.SetText("secret")
+	e.remember.SetChecked(true)
+	e.save()
+	if got, err := secrets.Get("Home"); err != nil || got != "secret" {
+		t.Fatalf("saved password = %q, %v", got, err)
+	}
+	if _, err := secrets.Get("Default"); err != errSecretNotFound {
+		t.Errorf("password of the former name kept: %v", err)
+	}
+
+	// Renaming moves the password
+	e.name.SetText("Garden")
+	e.save()
+	if got, err := secrets.Get("Garden"); err != nil || got != "secret" {
+		t.Errorf("renamed password = %q, %v", got, err)
+	}
+	if _, err := secrets.Get("Home"); err != errSecretNotFound {
+		t.Errorf("password of the former name kept: %v", err)
+	}
+
+	// The copy keeps the password
+	e.duplicate()
+	if e.name.Text != "Garden copy" || e.password.Text != "secret" {
+		t.Errorf("duplicate shows %q with password %q", e.name.Text, e.password.Text)
+	}
+	if got, err := secrets.Get("Garden copy"); err != nil || got != "secret" {
+		t.Errorf("duplicated password = %q, %v", got, err)
+	}
+	e.show(0)
+	e.show(1)
+	if e.password.Text != "secret" {
+		t.Errorf("duplicate shown again with password %q", e.password.Text)
+	}
+
+	// Forgetting the password deletes it
+	e.show(0)
+	e.remember.SetChecked(false)
+	e.save()
+	if _, err := secrets.Get("Garden"); err != errSecretNotFound {
+		t.Errorf("forgotten password kept: %v", err)
+	}
+	if profiles := app.loadProfiles(); len(profiles) != 2 || profiles[0].RememberPassword {
+		t.Errorf("saved profiles %+v", profiles)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"fyne.io/fyne/v2"
+
+	"github.com/zalando/go-keyring"
+)
+
+var errSecretNotFound = errors.New("secret not found")
+
+// secretStore keeps broker passwords outside of the preferences, keyed by
+// connection profile name.
+type secretStore interface {
+	Get(profile string) (string, error)
+	Set(profile, secret string) error
+	Delete(profile string) error
+}
+
+// newSecretStore uses the operating system keyring (Secret Service on Linux)
+// and falls back to an encrypted file in the application storage when it is
+// not available.
+func newSecretStore(a fyne.App) secretStore {
+	dir := a.Storage().RootURI().Path()
+
+	return &fallbackStore{
+		primary:  &keyringStore{service: a.UniqueID()},
+		fallback: &fileStore{path: filepath.Join(dir, "secrets.bin"), keyPath: filepath.Join(dir, "secrets.key")},
+	}
+}
+
+type fallbackStore struct {
+	primary  secretStore
+	fallback secretStore
+}
+
+func (s *fallbackStore) Get(profile string) (string, error) {
+	secret, err := s.primary.Get(profile)
+	if err == nil {
+		return secret, nil
+	}
+
+	return s.fallback.Get(profile)
+}
+
+func (s *fallbackStore) Set(profile, secret string) error {
+	if err := s.primary.Set(profile, secret); err != nil {
+		fyne.LogError("OS keyring unavailable, using encrypted file", err)
+		return s.fallback.Set(profile, secret)
+	}
+
+	// Do not leave an older copy behind in the fallback
+	s.fallback.Delete(profile)
+	return nil
+}
+
+func (s *fallbackStore) Delete(profile string) error {
+	errPrimary := s.primary.Delete(profile)
+	errFallback := s.fallback.Delete(profile)
+
+	if errPrimary != nil && errFallback != nil {
+		return errFallback
+	}
+	return nil
+}
+
+type keyringStore struct {
+	service string
+}
+
+func (s *keyringStore) Get(profile string) (string, error) {
+	secret, err := keyring.Get(s.service, profile)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", errSecretNotFound
+	}
+	return secret, err
+}
+
+func (s *keyringStore) Set(profile, secret string) error {
+	return keyring.Set(s.service, profile, secret)
+}
+
+func (s *keyringStore) Delete(profile string) error {
+	err := keyring.Delete(s.service, profile)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return errSecretNotFound
+	}
+	return err
+}
+
+// fileStore saves the secrets AES-GCM encrypted with a random key kept in a
+// separate file readable only by the user. It protects against the secrets
+// leaking with a copy of the preferences, not against someone able to read
+// the user files.
+type fileStore struct {
+	path    string
+	keyPath string
+
+	lock sync.Mutex
+}
+
+func (s *fileStore) Get(profile string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+
+	secret, ok := secrets[profile]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *fileStore) Set(profile, secret string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	secrets[profile] = secret
+	return s.save(secrets)
+}
+
+func (s *fileStore) Delete(profile string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secrets[profile]; !ok {
+		return errSecretNotFound
+	}
+
+	delete(secrets, profile)
+	return s.save(secrets)
+}
+
+func (s *fileStore) cipher() (cipher.AEAD, error) {
+	key, err := os.ReadFile(s.keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(filepath.Dir(s.keyPath), 0700); err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(s.keyPath, key, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *fileStore) load() (map[string]string, error) {
+	secrets := map[string]string{}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("corrupted secret file")
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets, json.Unmarshal(plain, &secrets)
+}
+
+func (s *fileStore) save(secrets map[string]string) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	aead, err := s.cipher()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.path, aead.Seal(nonce, nonce, plain, nil), 0600)
+}
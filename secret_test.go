@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memoryStore is an in-memory secretStore for tests.
+type memoryStore struct {
+	lock    sync.Mutex
+	secrets map[string]string
+	err     error // returned by every call when set, like an unavailable keyring
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{secrets: map[string]string{}}
+}
+
+func (s *memoryStore) Get(profile string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return "", s.err
+	}
+	secret, ok := s.secrets[profile]
+	if !ok {
+		return "", errSecretNotFound
+	}
+	return secret, nil
+}
+
+func (s *memoryStore) Set(profile, secret string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.secrets[profile] = secret
+	return nil
+}
+
+func (s *memoryStore) Delete(profile string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if _, ok := s.secrets[profile]; !ok {
+		return errSecretNotFound
+	}
+	delete(s.secrets, profile)
+	return nil
+}
+
+func TestFallbackStore(t *testing.T) {
+	primary, fallback := newMemoryStore(), newMemoryStore()
+	s := &fallbackStore{primary: primary, fallback: fallback}
+
+	fallback.secrets["home"] = "old"
+	if err := s.Set("home", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if primary.secrets["home"] != "secret" {
+		t.Errorf("primary has %q, want secret", primary.secrets["home"])
+	}
+	if _, ok := fallback.secrets["home"]; ok {
+		t.Error("older fallback copy was kept")
+	}
+
+	primary.err = errors.New("keyring unavailable")
+	if err := s.Set("lab", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if fallback.secrets["lab"] != "other" {
+		t.Errorf("fallback has %q, want other", fallback.secrets["lab"])
+	}
+	if secret, err := s.Get("lab"); err != nil || secret != "other" {
+		t.Errorf("Get = %q, %v, want other", secret, err)
+	}
+
+	if err := s.Delete("lab"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := s.Get("lab"); !errors.Is(err, errSecretNotFound) {
+		t.Errorf("Get after Delete = %v, want errSecretNotFound", err)
+	}
+	if err := s.Delete("missing"); err == nil {
+		t.Error("deleting a missing secret succeeded")
+	}
+}
+
+func newTestFileStore(t *testing.T) *fileStore {
+	dir := t.TempDir()
+	return &fileStore{path: filepath.Join(dir, "secrets.bin"), keyPath: filepath.Join(dir, "secrets.key")}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	s := newTestFileStore(t)
+
+	if _, err := s.Get("home"); !errors.Is(err, errSecretNotFound) {
+		t.Fatalf("Get on an empty store = %v, want errSecretNotFound", err)
+	}
+	if err := s.Set("home", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("lab", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("secrets are saved in clear")
+	}
+
+	// A new store reading the same files finds the secrets back
+	reopened := &fileStore{path: s.path, keyPath: s.keyPath}
+	if secret, err := reopened.Get("home"); err != nil || secret != "secret" {
+		t.Errorf("Get = %q, %v, want secret", secret, err)
+	}
+
+	if err := reopened.Delete("home"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Get("home"); !errors.Is(err, errSecretNotFound) {
+		t.Errorf("Get after Delete = %v, want errSecretNotFound", err)
+	}
+	if err := reopened.Delete("home"); !errors.Is(err, errSecretNotFound) {
+		t.Errorf("second Delete = %v, want errSecretNotFound", err)
+	}
+	if secret, err := reopened.Get("lab"); err != nil || secret != "other" {
+		t.Errorf("Get = %q, %v, want other", secret, err)
+	}
+}
+
+func TestFileStoreWrongKey(t *testing.T) {
+	s := newTestFileStore(t)
+	if err := s.Set("home", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(s.keyPath, make([]byte, 32), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("home"); err == nil || errors.Is(err, errSecretNotFound) {
+		t.Errorf("Get with a wrong key = %v, want a decryption error", err)
+	}
+}
+
+func TestFileStoreCorrupted(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"truncated": {1, 2, 3},
+		"tampered":  nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newTestFileStore(t)
+			if err := s.Set("home", "secret"); err != nil {
+				t.Fatal(err)
+			}
+
+			if data == nil {
+				saved, err := os.ReadFile(s.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				saved[len(saved)-1] ^= 0xff
+				data = saved
+			}
+			if err := os.WriteFile(s.path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := s.Get("home"); err == nil {
+				t.Error("Get on a corrupted file succeeded")
+			}
+			if err := s.Delete("home"); err == nil {
+				t.Error("Delete on a corrupted file succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	xbinding "fyne.io/x/fyne/data/binding"
+)
+
+var hiddenSectionsKey = "hiddenSections"
+
+// observationField describes how to display one value of the weatherflow2mqtt observation.
+type observationField struct {
+	key      string
+	label    string
+	format   string                            // printf verb for numbers, empty for text values
+	unit     string                            // fixed unit, or suffix of the converted unit
+	quantity func(*unitSettings) *unitQuantity // converts the value to the selected unit when set
+}
+
+type cardSection struct {
+	name   string
+	fields []observationField
+}
+
+func temperatureQuantity(u *unitSettings) *unitQuantity { return u.temperature }
+func speedQuantity(u *unitSettings) *unitQuantity       { return u.speed }
+func pressureQuantity(u *unitSettings) *unitQuantity    { return u.pressure }
+func rainQuantity(u *unitSettings) *unitQuantity        { return u.rain }
+func distanceQuantity(u *unitSettings) *unitQuantity    { return u.distance }
+
+var cardSections = []cardSection{
+	{name: "Temperature", fields: []observationField{
+		{key: "air_temperature", label: "Air", format: "%.1f", quantity: temperatureQuantity},
+		{key: "feelslike", label: "Feels like", format: "%.1f", quantity: temperatureQuantity},
+		{key: "dewpoint", label: "Dew point", format: "%.1f", quantity: temperatureQuantity},
+		{key: "wetbulb", label: "Wet bulb", format: "%.1f", quantity: temperatureQuantity},
+		{key: "wbgt", label: "WBGT", format: "%.1f", quantity: temperatureQuantity},
+		{key: "relative_humidity", label: "Humidity", format: "%.1f", unit: "%"},
+		{key: "absolute_humidity", label: "Absolute humidity", format: "%.2f", unit: "g/m³"},
+		{key: "temperature_description", label: "Feeling"},
+		{key: "dewpoint_description", label: "Dryness"},
+	}},
+	{name: "Wind", fields: []observationField{
+		{key: "wind_speed", label: "Speed", format: "%.1f", quantity: speedQuantity},
+		{key: "wind_gust", label: "Gust", format: "%.1f", quantity: speedQuantity},
+		{key: "wind_lull", label: "Lull", format: "%.1f", quantity: speedQuantity},
+		{key: "wind_speed_avg", label: "Average", format: "%.1f", quantity: speedQuantity},
+		{key: "wind_direction", label: "Direction", format: "%.0f", unit: "°"},
+		{key: "wind_direction_avg", label: "Average direction"},
+		{key: "beaufort", label: "Beaufort", format: "%.0f"},
+		{key: "beaufort_description", label: "Description"},
+	}},
+	{name: "Precipitation", fields: []observationField{
+		{key: "precipitation_type", label: "Type"},
+		{key: "rain_intensity", label: "Intensity"},
+		{key: "rain_rate", label: "Rate", format: "%.2f", unit: "/h", quantity: rainQuantity},
+		{key: "rain_today", label: "Today", format: "%.2f", quantity: rainQuantity},
+		{key: "rain_yesterday", label: "Yesterday", format: "%.2f", quantity: rainQuantity},
+		{key: "rain_duration_today", label: "Duration today", format: "%.0f", unit: "min"},
+		{key: "rain_start_time", label: "Started"},
+	}},
+	{name: "Lightning", fields: []observationField{
+		{key: "lightning_strike_count", label: "Strikes", format: "%.0f"},
+		{key: "lightning_strike_count_1hr", label: "Last hour", format: "%.0f"},
+		{key: "lightning_strike_count_3hr", label: "Last 3 hours", format: "%.0f"},
+		{key: "lightning_strike_count_today", label: "Today", format: "%.0f"},
+		{key: "lightning_strike_distance", label: "Distance", format: "%.0f", quantity: distanceQuantity},
+		{key: "lightning_strike_energy", label: "Energy", format: "%.0f"},
+		{key: "lightning_strike_time", label: "Last strike"},
+	}},
+	{name: "Sun", fields: []observationField{
+		{key: "solar_radiation", label: "Solar radiation", format: "%.0f", unit: "W/m²"},
+		{key: "illuminance", label: "Illuminance", format: "%.0f", unit: "lx"},
+		{key: "uv", label: "UV index", format: "%.2f"},
+		{key: "uv_description", label: "UV"},
+		{key: "visibility", label: "Visibility", format: "%.1f", quantity: distanceQuantity},
+	}},
+	{name: "Station health", fields: []observationField{
+		{key: "battery", label: "Battery", format: "%.2f", unit: "V"},
+		{key: "battery_level", label: "Battery level", format: "%.0f", unit: "%"},
+		{key: "battery_mode_description", label: "Battery mode"},
+		{key: "air_density", label: "Air density", format: "%.3f", unit: "kg/m³"},
+		{key: "status", label: "Status", format: "%.0f"},
+		{key: "last_reset_midnight", label: "Last reset"},
+	}},
+}
+
+// bind returns a string binding of the field formatted with its unit.
+func (f observationField) bind(json xbinding.JSONValue, card *weatherCard) (binding.String, error) {
+	if f.format == "" {
+		return json.GetItemString(f.key)
+	}
+
+	value, err := json.GetItemFloat(f.key)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := strings.ReplaceAll(f.unit, "%", "%%")
+	if f.quantity == nil {
+		if unit != "" && unit != "%%" {
+			unit = " " + unit
+		}
+		return binding.NewSprintf(f.format+unit, value), nil
+	}
+
+	q := f.quantity(card.units)
+	return binding.NewSprintf(f.format+" %s"+unit, card.convert(q, value), card.unit(q)), nil
+}
+
+// hiddenSections returns the names of the sections the user does not want on the cards.
+func (app *application) hiddenSections() map[string]bool {
+	hidden := map[string]bool{}
+	for _, name := range app.app.Preferences().StringList(hiddenSectionsKey) {
+		hidden[name] = true
+	}
+	return hidden
+}
+
+func (app *application) sectionsDialogShow() {
+	hidden := app.hiddenSections()
+
+	checks := make([]*widget.Check, len(cardSections))
+	items := make([]*widget.FormItem, len(cardSections))
+	for i, section := range cardSections {
+		checks[i] = widget.NewCheck("Visible", nil)
+		checks[i].SetChecked(!hidden[section.name])
+		items[i] = &widget.FormItem{Text: section.name, Widget: checks[i]}
+	}
+
+	dialog.ShowForm("Card sections", "Apply", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+
+		names := []string{}
+		for i, section := range cardSections {
+			if !checks[i].Checked {
+				names = append(names, section.name)
+			}
+		}
+		app.app.Preferences().SetStringList(hiddenSectionsKey, names)
+
+		hidden := app.hiddenSections()
+		for _, card := range app.cardList() {
+			card.showSections(hidden)
+		}
+	}, app.window)
+}
+
+func (card *weatherCard) makeSections(hidden map[string]bool) *widget.Accordion {
+	card.details = map[string]*widget.Label{}
+	card.sectionItems = make([]*widget.AccordionItem, len(cardSections))
+	card.todayItem = widget.NewAccordionItem("Today", card.today.form)
+
+	for i, section := range cardSections {
+		form := widget.NewForm()
+		for _, field := range section.fields {
+			label := widget.NewLabel("-")
+			card.details[field.key] = label
+			form.Append(field.label+":", label)
+		}
+		var content fyne.CanvasObject = form
+		if section.name == "Lightning" {
+			content = container.NewVBox(form, card.lightning.content)
+		}
+		card.sectionItems[i] = widget.NewAccordionItem(section.name, content)
+	}
+
+	card.sections = widget.NewAccordion()
+	card.sections.MultiOpen = true
+	card.showSections(hidden)
+
+	return card.sections
+}
+
+func (card *weatherCard) showSections(hidden map[string]bool) {
+	items := []*widget.AccordionItem{card.todayItem}
+	for i, section := range cardSections {
+		if !hidden[section.name] {
+			items = append(items, card.sectionItems[i])
+		}
+	}
+
+	card.sections.Items = items
+	card.sections.Refresh()
+}
+
+func (card *weatherCard) bindSections(json xbinding.JSONValue) error {
+	for _, section := range cardSections {
+		for _, field := range section.fields {
+			text, err := field.bind(json, card)
+			if err != nil {
+				return err
+			}
+			card.details[field.key].Bind(text)
+		}
+	}
+
+	return nil
+}
+
+func (card *weatherCard) unbindSections() {
+	for _, label := range card.details {
+		label.Unbind()
+	}
+}
@@ -1,19 +1,22 @@
 package main
 
 import (
-	"regexp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
-	"fyne.io/fyne/v2/data/validation"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/google/uuid"
 )
 
+// mqttBrokerKey is the preference used before connection profiles existed.
 var mqttBrokerKey = "mqttBroker"
 
 func (app *application) standbyDialogShow(broker string) (dialog.Dialog, *widget.Label) {
@@ -29,155 +32,299 @@ func (app *application) standbyDialogShow(broker string) (dialog.Dialog, *widget
 	return d, action
 }
 
-func (app *application) waitCancelOrStepSuccess(token mqtt.Token, d dialog.Dialog, card *weatherCard) bool {
-	select {
-	case <-card.cancel:
-		card.stop = true
+// connectionErrorShow reports why connecting failed, then lets the user try again.
+func (app *application) connectionErrorShow(err error) {
+	d := dialog.NewError(err, app.window)
+	d.SetOnClosed(app.connectionDialogShow)
+	d.Show()
+}
 
+// waitStep waits for an MQTT operation to complete, unless the session is
+// cancelled first, returning why it was.
+func waitStep(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
 	case <-token.Done():
-		if token.Error() != nil {
-			card.stop = true
-		}
+		return token.Error()
 	}
+}
 
-	if card.stop {
-		app.card.stopMqtt(d)
+// failConnect stops a session that could not be set up, then lets the user
+// try again, reporting the error unless the user cancelled.
+func (app *application) failConnect(d dialog.Dialog, err error) {
+	app.stopMqtt(d)
 
+	if errors.Is(err, context.Canceled) {
 		app.connectionDialogShow()
-		return false
+		return
 	}
-
-	return true
+	app.connectionErrorShow(err)
 }
 
-func (app *application) asynchronousConnect(d dialog.Dialog, standbyAction *widget.Label, broker string) {
-	// Setup action on dialog close action
-	d.SetOnClosed(func() {
-		if !app.card.stop {
-			app.card.cancel <- struct{}{}
-		}
-	})
+func (app *application) asynchronousConnect(ctx context.Context, d dialog.Dialog, standbyAction *widget.Label, client mqttClient, profile connectionProfile) {
+	// Cancelling the standby dialog aborts the setup, hiding it once live does nothing
+	d.SetOnClosed(app.conn.abort)
 
 	// Connect to MQTT and wait on either user cancel or success
-	if !app.waitCancelOrStepSuccess(app.card.client.Connect(), d, app.card) {
+	if err := waitStep(ctx, client.Connect()); err != nil {
+		app.failConnect(d, err)
 		return
 	}
 
-	app.app.Preferences().SetString(mqttBrokerKey, broker)
+	app.app.Preferences().SetString(lastProfileKey, profile.Name)
+
+	serials := profile.Stations
+	if len(serials) == 0 {
+		if !app.conn.transition(stateConnecting, stateDiscovering) {
+			return
+		}
+
+		serial, err := app.discoverStation(ctx, standbyAction, client, profile)
+		if err != nil {
+			app.failConnect(d, err)
+			return
+		}
+		serials = []string{serial}
+	}
+
+	app.showStations(serials)
+	app.saveStations()
 
-	topicMatch := regexp.MustCompile(`homeassistant/sensor/weatherflow2mqtt_ST-(\d+)/status/attributes`)
+	if !app.conn.transition(stateConnecting, stateWaitingForData) && !app.conn.transition(stateDiscovering, stateWaitingForData) {
+		return
+	}
+	app.bindStations(ctx, d, standbyAction)
+}
+
+// discoverStation waits for the stations announcing themselves on the broker
+// and lets the user pick one when there are several.
+func (app *application) discoverStation(ctx context.Context, standbyAction *widget.Label, client mqttClient, profile connectionProfile) (string, error) {
+	topics, err := profile.topics()
+	if err != nil {
+		return "", err
+	}
+	configTopic := haConfigTopic(profile.topicPrefix())
+	app.conn.setDiscovery(topics.discovery, configTopic)
 
 	standbyAction.SetText("Waiting for MQTT sensor identification.")
 
-	deduplicate := false
+	discovery := newStationDiscovery(topics, func(count int) {
+		standbyAction.SetText(fmt.Sprintf("Found %d weather station(s), looking for more.", count))
+	})
 
-	// Subscribe to a topic that will give us the serial number of a Tempest weather station
-	token := app.card.client.Subscribe("homeassistant/sensor/+/status/attributes", 1, func(client mqtt.Client, msg mqtt.Message) {
-		r := topicMatch.FindStringSubmatch(msg.Topic())
-		if len(r) == 0 || deduplicate {
-			return
-		}
+	// Subscribe to the topics giving us the serial number of Tempest weather
+	// stations and the weather sensors announced to Home Assistant
+	if err := waitStep(ctx, client.Subscribe(topics.discovery, profile.QoS, discovery.handle)); err != nil {
+		return "", err
+	}
+	if err := waitStep(ctx, client.Subscribe(configTopic, profile.QoS, discovery.handleConfig)); err != nil {
+		return "", err
+	}
 
-		deduplicate = true
+	// Wait for a first station, then give the other stations some time to show up
+	if err := waitStep(ctx, discovery.first); err != nil {
+		return "", err
+	}
+	window := newToken()
+	time.AfterFunc(discoveryWindow, func() { window.complete(nil) })
+	if err := waitStep(ctx, window); err != nil {
+		return "", err
+	}
 
-		// Stop looking for any additional serial number
-		app.card.client.Unsubscribe("homeassistant/sensor/+/status/attributes")
+	// Stop looking for any additional serial number
+	if topics := app.conn.takeDiscovery(); len(topics) > 0 {
+		client.Unsubscribe(topics...)
+	}
 
-		standbyAction.SetText("Waiting for first MQTT data.")
+	stations := discovery.list()
+	if len(stations) == 1 {
+		app.rememberDevice(stations[0])
+		return stations[0].serial, nil
+	}
 
-		// Connect the MQTT session to the widget
-		json, err := app.card.connectWeather2Mqtt(r[1])
-		if err != nil {
-			app.card.stopMqtt(d)
+	choice := app.stationChooserShow(stations, "")
+	if err := waitStep(ctx, choice); err != nil {
+		choice.dialog.Hide()
+		return "", err
+	}
+	if choice.serial == "" {
+		return "", context.Canceled
+	}
 
-			app.connectionDialogShow()
-			return
+	for _, s := range stations {
+		if s.serial == choice.serial {
+			app.rememberDevice(s)
 		}
+	}
+	return choice.serial, nil
+}
 
-		// Wait for the first valid live data to arrive
-		var listener binding.DataListener
+// bindStations connects every card to the observations of its station and
+// hides the standby dialog once the first data arrived.
+func (app *application) bindStations(ctx context.Context, d dialog.Dialog, standbyAction *widget.Label) {
+	standbyAction.SetText("Waiting for first MQTT data.")
 
-		listener = binding.NewDataListener(func() {
-			if json.IsEmpty() {
+	for _, card := range app.cardList() {
+		err := app.bindCard(card, func() {
+			if !app.conn.transition(stateWaitingForData, stateLive) {
 				return
 			}
 
-			json.RemoveListener(listener)
-
-			app.card.stop = true
-			close(app.card.cancel)
-
-			app.card.Enable()
-			app.card.action.SetText("Disconnect")
+			app.action.SetText("Disconnect")
+			app.addStation.Enable()
 
 			d.Hide()
 		})
+		if err != nil {
+			app.failConnect(d, err)
+			return
+		}
+	}
 
-		json.AddListener(listener)
+	// Stop when the user cancels or the connection is lost while still waiting for the first data
+	go func() {
+		<-ctx.Done()
 
-		// This goroutine wait for the chanel to notify a cancellation or to be close as a synchronization point.
-		go func() {
-			<-app.card.cancel
+		if app.conn.current() == stateWaitingForData {
+			app.failConnect(d, context.Cause(ctx))
+		}
+	}()
+}
 
-			if !app.card.stop {
-				app.card.stopMqtt(nil)
+// bindCard connects the card to its station and enables it once its first data arrived.
+func (app *application) bindCard(card *weatherCard, ready func()) error {
+	client := app.conn.session()
+	if client == nil {
+		return mqtt.ErrNotConnected
+	}
 
-				app.connectionDialogShow()
-			}
-		}()
+	topics, err := app.conn.profile.topics()
+	if err != nil {
+		return err
+	}
+
+	json, err := card.connectWeather2Mqtt(client, topics)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the first valid live data to arrive
+	var listener binding.DataListener
+
+	listener = binding.NewDataListener(func() {
+		if json.IsEmpty() {
+			return
+		}
+
+		json.RemoveListener(listener)
+
+		card.Enable()
+		ready()
 	})
-	// Wait for Subscribe to successful set up or user cancel
-	app.waitCancelOrStepSuccess(token, d, app.card)
+
+	json.AddListener(listener)
+	return nil
 }
 
-func (app *application) connectionDialogShow() {
-	broker := widget.NewEntry()
-	broker.SetPlaceHolder("tcp://broker.emqx.io:1883/")
-	broker.Validator = validation.NewRegexp(`(tcp|ws)://[a-z0-9-._-]+:\d+/`, "not a valid broker address")
+// stopMqtt closes the session whatever its state, several calls only stop it once.
+func (app *application) stopMqtt(d dialog.Dialog) {
+	if d != nil {
+		d.Hide()
+	}
 
-	if s := app.app.Preferences().String(mqttBrokerKey); s != "" {
-		broker.SetText(s)
+	client := app.conn.stop()
+	if client == nil {
+		return
 	}
 
-	user := widget.NewEntry()
-	user.SetPlaceHolder("anonymous")
+	if topics := app.conn.takeDiscovery(); len(topics) > 0 && client.IsConnected() {
+		client.Unsubscribe(topics...)
+	}
+
+	for _, card := range app.cardList() {
+		card.disconnect()
+	}
 
-	password := widget.NewPasswordEntry()
-	password.SetPlaceHolder("")
+	app.action.SetText("Connect")
+	app.addStation.Disable()
+	app.status.stopped()
+	client.Disconnect(0)
 
-	form := dialog.NewForm("Mqtt broker settings", "Connect", "Cancel",
-		[]*widget.FormItem{
-			{Text: "Broker", Widget: broker, HintText: "MQTT broker to connect to"},
-			{Text: "User", Widget: user, HintText: "User to use for connecting (optional)"},
-			{Text: "Password", Widget: password, HintText: "User password to use for connecting (optional)"},
-		},
+	app.conn.stopped()
+}
+
+func (app *application) fileEntry(placeholder string) (*widget.Entry, fyne.CanvasObject) {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder(placeholder)
+
+	browse := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil || r == nil {
+				return
+			}
+			defer r.Close()
+
+			entry.SetText(r.URI().Path())
+		}, app.window)
+	})
+
+	return entry, container.NewBorder(nil, nil, nil, browse, entry)
+}
+
+func (app *application) connectionDialogShow() {
+	editor := app.newProfileEditor()
+
+	form := dialog.NewForm("Mqtt broker settings", "Connect", "Cancel", editor.formItems(),
 		func(confirm bool) {
 			if !confirm {
 				return
 			}
 
+			editor.save()
+			profile := editor.profiles[editor.current]
+
 			opts := mqtt.NewClientOptions()
-			opts.AddBroker(broker.Text)
-			opts.SetClientID("FyneLabs.weather." + uuid.NewString())
-			if user.Text != "" {
-				opts.SetUsername(user.Text)
+			opts.AddBroker(profile.Broker)
+			opts.SetClientID(profile.clientID())
+			if profile.User != "" {
+				opts.SetUsername(profile.User)
 			}
-			if password.Text != "" {
-				opts.SetPassword(password.Text)
+			if editor.password.Text != "" {
+				opts.SetPassword(editor.password.Text)
 			}
 			opts.AutoReconnect = true
 
-			d, standbyAction := app.standbyDialogShow(broker.Text)
+			if _, err := profile.topics(); err != nil {
+				app.connectionErrorShow(err)
+				return
+			}
+
+			if isSecureBroker(profile.Broker) {
+				cfg, err := profile.TLS.config()
+				if err != nil {
+					app.connectionErrorShow(err)
+					return
+				}
+				opts.SetTLSConfig(cfg)
+			}
 
-			app.card.cancel = make(chan struct{})
-			app.card.stop = false
-			app.card.client = mqtt.NewClient(opts)
+			client := newMqttClient(opts, profile, app.connectionEvents())
+			ctx, ok := app.conn.start(client, profile)
+			if !ok {
+				dialog.ShowInformation("Mqtt broker settings", "Disconnect before connecting again.", app.window)
+				return
+			}
+			app.status.follow(client)
 
-			go app.asynchronousConnect(d, standbyAction, broker.Text)
+			d, standbyAction := app.standbyDialogShow(profile.Broker)
+			go app.asynchronousConnect(ctx, d, standbyAction, client, profile)
 		}, app.window)
 
-	form.Resize(fyne.NewSize(400, 100))
+	form.Resize(fyne.NewSize(500, 100))
 	form.Show()
 
-	app.card.Disable()
+	for _, card := range app.cardList() {
+		card.Disable()
+	}
 }
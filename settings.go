@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+)
+
+func (app *application) settingsDialogShow() {
+	unitItems, applyUnits := app.unitFormItems()
+	historyItems, applyHistory := app.historyFormItems()
+	storeItems, applyStore := app.storeFormItems()
+	staleItems, applyStale := app.staleFormItems()
+
+	d := dialog.NewForm("Settings", "Apply", "Cancel", append(append(append(unitItems, historyItems...), storeItems...), staleItems...), func(ok bool) {
+		if !ok {
+			return
+		}
+
+		applyUnits()
+		applyHistory()
+		applyStore()
+		applyStale()
+	}, app.window)
+	d.Resize(fyne.NewSize(350, 100))
+	d.Show()
+}
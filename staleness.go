@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	staleAfterKey     = "staleAfter"
+	defaultStaleAfter = 5 * time.Minute
+)
+
+// formatAge prints how long ago something happened with a precision matching its age.
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d s", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d h", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+// bindStatus follows the status topic of the station, which keeps being
+// published by weatherflow2mqtt even when the observations stop.
+func (card *weatherCard) bindStatus(client mqttClient, topics stationTopics) error {
+	status, err := newMqttString(client, topics.statusTopic(card.serial))
+	if err != nil {
+		return err
+	}
+
+	card.freshness.Lock()
+	card.status = status
+	card.lastStatus = time.Now()
+	card.freshness.Unlock()
+
+	status.AddListener(binding.NewDataListener(func() {
+		if s, _ := status.Get(); s != "" {
+			card.touch(&card.lastStatus)
+		}
+	}))
+	return nil
+}
+
+func (card *weatherCard) touch(last *time.Time) {
+	card.freshness.Lock()
+	*last = time.Now()
+	card.freshness.Unlock()
+}
+
+// refreshFreshness shows how old the displayed observation is and flags the
+// card when the station stopped publishing for longer than staleAfter.
+func (card *weatherCard) refreshFreshness(now time.Time, staleAfter time.Duration) {
+	card.freshness.Lock()
+	observation, status, live, followed := card.lastObservation, card.lastStatus, card.live, card.status != nil
+	card.freshness.Unlock()
+
+	if observation.IsZero() {
+		card.updated.SetText("")
+		return
+	}
+
+	age := now.Sub(observation)
+	text := "updated " + formatAge(age) + " ago"
+	stale := live && age > staleAfter
+	if quiet := now.Sub(status); followed && quiet > staleAfter {
+		text += ", station status quiet for " + formatAge(quiet)
+		stale = true
+	}
+
+	importance := widget.LowImportance
+	if stale {
+		importance = widget.WarningImportance
+		text = "⚠ " + text
+	}
+	card.updated.Importance = importance
+	card.updated.SetText(text)
+
+	card.freshness.Lock()
+	changed := stale != card.stale
+	card.stale = stale
+	card.freshness.Unlock()
+
+	if changed {
+		card.refreshOverlay()
+	}
+}
+
+func (app *application) staleAfter() time.Duration {
+	minutes := app.app.Preferences().IntWithFallback(staleAfterKey, int(defaultStaleAfter/time.Minute))
+	return time.Duration(minutes) * time.Minute
+}
+
+// watchFreshness keeps the "updated" indicator and the age of the last
+// lightning strike of every card current.
+func (app *application) watchFreshness() {
+	for now := range time.Tick(time.Second) {
+		staleAfter := app.staleAfter()
+		for _, card := range app.cardList() {
+			card.refreshFreshness(now, staleAfter)
+			card.lightning.refreshLatest(now)
+		}
+	}
+}
+
+func (app *application) staleFormItems() ([]*widget.FormItem, func()) {
+	staleAfter := widget.NewSelect([]string{"2", "5", "10", "30"}, nil)
+	staleAfter.SetSelected(strconv.Itoa(int(app.staleAfter() / time.Minute)))
+
+	return []*widget.FormItem{{Text: "Stale after", Widget: staleAfter, HintText: "Minutes without data before a card is flagged"}}, func() {
+		minutes, err := strconv.Atoi(staleAfter.Selected)
+		if err != nil {
+			fyne.LogError("Invalid staleness interval", err)
+			return
+		}
+		app.app.Preferences().SetInt(staleAfterKey, minutes)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/widget"
+)
+
+const statsDayLayout = "2006-01-02"
+
+// extreme is a remarkable value of the day and when it was observed.
+type extreme struct {
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time"`
+	Valid bool      `json:"valid"`
+}
+
+func (e *extreme) keep(v float64, t time.Time, better func(v, current float64) bool) {
+	if !e.Valid || better(v, e.Value) {
+		*e = extreme{Value: v, Time: t, Valid: true}
+	}
+}
+
+func higher(v, current float64) bool { return v > current }
+func lower(v, current float64) bool  { return v < current }
+
+// dayStats are the statistics of a station over one day, saved as JSON.
+type dayStats struct {
+	Day   string `json:"day"`   // local date the statistics started
+	Reset string `json:"reset"` // last_reset_midnight of the station, when published
+
+	TemperatureHigh extreme `json:"temperatureHigh"`
+	TemperatureLow  extreme `json:"temperatureLow"`
+	GustMax         extreme `json:"gustMax"`
+	UVMax           extreme `json:"uvMax"`
+	HumidityMin     extreme `json:"humidityMin"`
+	HumidityMax     extreme `json:"humidityMax"`
+	WindSum         float64 `json:"windSum"`
+	WindCount       int     `json:"windCount"`
+}
+
+// dailyStats are the running statistics of a station since the last midnight.
+type dailyStats struct {
+	lock sync.Mutex
+	save func([]byte)
+	day  dayStats
+}
+
+func dailyStatsKey(serial string) string {
+	return "dailyStats-" + serial
+}
+
+// loadDailyStats returns the statistics saved for the station, if they are still about today.
+func (app *application) loadDailyStats(serial string) *dailyStats {
+	prefs := app.app.Preferences()
+	key := dailyStatsKey(serial)
+
+	stats := &dailyStats{}
+	if saved := prefs.String(key); saved != "" {
+		if err := json.Unmarshal([]byte(saved), &stats.day); err != nil {
+			fyne.LogError("Unable to read the daily statistics of "+serial, err)
+		}
+	}
+	// Statistics of a previous day are stale, even from a station publishing its reset time
+	if stats.day.Day != time.Now().Format(statsDayLayout) {
+		stats.day = dayStats{}
+	}
+
+	stats.save = func(data []byte) {
+		if serial != "" {
+			prefs.SetString(key, string(data))
+		}
+	}
+	return stats
+}
+
+// update takes an observation into account, starting a new day first when
+// the station reset its counters or, without that information, at local midnight.
+func (s *dailyStats) update(t time.Time, payload string) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return
+	}
+	number := func(key string) (float64, bool) {
+		v, ok := fields[key].(float64)
+		return v, ok
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	day := t.Local().Format(statsDayLayout)
+	reset, _ := fields["last_reset_midnight"].(string)
+	if (reset != "" && reset != s.day.Reset) || (reset == "" && day != s.day.Day) {
+		s.day = dayStats{Day: day, Reset: reset}
+	}
+
+	if v, ok := number("air_temperature"); ok {
+		s.day.TemperatureHigh.keep(v, t, higher)
+		s.day.TemperatureLow.keep(v, t, lower)
+	}
+	if v, ok := number("wind_gust"); ok {
+		s.day.GustMax.keep(v, t, higher)
+	}
+	if v, ok := number("uv"); ok {
+		s.day.UVMax.keep(v, t, higher)
+	}
+	if v, ok := number("relative_humidity"); ok {
+		s.day.HumidityMin.keep(v, t, lower)
+		s.day.HumidityMax.keep(v, t, higher)
+	}
+	if v, ok := number("wind_speed"); ok {
+		s.day.WindSum += v
+		s.day.WindCount++
+	}
+
+	data, err := json.Marshal(s.day)
+	if err != nil {
+		fyne.LogError("Unable to save the daily statistics", err)
+		return
+	}
+	s.save(data)
+}
+
+// statsPanel shows the daily statistics of a card.
+type statsPanel struct {
+	stats    *dailyStats
+	units    *unitSettings
+	listener binding.DataListener // follows the unit settings until close
+
+	high, low, gust, uv, humidity, wind *widget.Label
+	form                                *widget.Form
+}
+
+func newStatsPanel(stats *dailyStats, units *unitSettings) *statsPanel {
+	p := &statsPanel{stats: stats, units: units,
+		high: widget.NewLabel("-"), low: widget.NewLabel("-"), gust: widget.NewLabel("-"),
+		uv: widget.NewLabel("-"), humidity: widget.NewLabel("-"), wind: widget.NewLabel("-"),
+	}
+	p.form = widget.NewForm(
+		widget.NewFormItem("High:", p.high),
+		widget.NewFormItem("Low:", p.low),
+		widget.NewFormItem("Max gust:", p.gust),
+		widget.NewFormItem("Max UV:", p.uv),
+		widget.NewFormItem("Humidity:", p.humidity),
+		widget.NewFormItem("Average wind:", p.wind),
+	)
+
+	p.listener = binding.NewDataListener(p.refresh)
+	for _, q := range units.quantities() {
+		q.unit.AddListener(p.listener)
+	}
+	return p
+}
+
+// close stops following the unit settings, once the card is removed.
+func (p *statsPanel) close() {
+	for _, q := range p.units.quantities() {
+		q.unit.RemoveListener(p.listener)
+	}
+}
+
+// snapshot returns a copy of the statistics of the day.
+func (s *dailyStats) snapshot() dayStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.day
+}
+
+func (p *statsPanel) refresh() {
+	s := p.stats.snapshot()
+
+	at := func(e extreme, format func(float64) string) string {
+		if !e.Valid {
+			return "-"
+		}
+		return format(e.Value) + " at " + e.Time.Local().Format("15:04")
+	}
+	temperature := p.units.temperature.format("%.1f")
+	speed := p.units.speed.format("%.1f")
+
+	p.high.SetText(at(s.TemperatureHigh, temperature))
+	p.low.SetText(at(s.TemperatureLow, temperature))
+	p.gust.SetText(at(s.GustMax, speed))
+	p.uv.SetText(at(s.UVMax, func(v float64) string { return fmt.Sprintf("%.2f", v) }))
+
+	if s.HumidityMin.Valid {
+		p.humidity.SetText(fmt.Sprintf("%.0f%% – %.0f%%", s.HumidityMin.Value, s.HumidityMax.Value))
+	} else {
+		p.humidity.SetText("-")
+	}
+	if s.WindCount > 0 {
+		p.wind.SetText(speed(s.WindSum / float64(s.WindCount)))
+	} else {
+		p.wind.SetText("-")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/test"
+)
+
+func TestDailyStatsUpdate(t *testing.T) {
+	stats := &dailyStats{save: func([]byte) {}}
+	today := time.Now()
+
+	stats.update(today, `{"air_temperature":12,"wind_gust":5,"wind_speed":2,"last_reset_midnight":"a"}`)
+	stats.update(today, `{"air_temperature":18,"wind_gust":3,"wind_speed":4,"last_reset_midnight":"a"}`)
+	day := stats.snapshot()
+	if day.TemperatureHigh.Value != 18 || day.TemperatureLow.Value != 12 || day.GustMax.Value != 5 || day.WindSum/float64(day.WindCount) != 3 {
+		t.Errorf("unexpected statistics %+v", day)
+	}
+
+	// A new reset time from the station starts a new day
+	stats.update(today, `{"air_temperature":8,"last_reset_midnight":"b"}`)
+	day = stats.snapshot()
+	if day.TemperatureHigh.Value != 8 || day.GustMax.Valid || day.WindCount != 0 {
+		t.Errorf("statistics not reset: %+v", day)
+	}
+}
+
+func TestLoadDailyStats(t *testing.T) {
+	a := test.NewTempApp(t)
+	app := &application{app: a}
+
+	for name, tt := range map[string]struct {
+		day   dayStats
+		valid bool
+	}{
+		"today":               {dayStats{Day: time.Now().Format(statsDayLayout)}, true},
+		"yesterday":           {dayStats{Day: time.Now().AddDate(0, 0, -1).Format(statsDayLayout)}, false},
+		"yesterday and reset": {dayStats{Day: time.Now().AddDate(0, 0, -1).Format(statsDayLayout), Reset: "2024-06-01"}, false},
+	} {
+		tt.day.TemperatureHigh = extreme{Value: 21, Time: time.Now(), Valid: true}
+		data, _ := json.Marshal(tt.day)
+		a.Preferences().SetString(dailyStatsKey("1234"), string(data))
+
+		if got := app.loadDailyStats("1234").snapshot().TemperatureHigh.Valid; got != tt.valid {
+			t.Errorf("%s: loaded statistics valid = %v, want %v", name, got, tt.valid)
+		}
+	}
+}
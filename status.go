@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+)
+
+// connectionStatus follows the MQTT session as reported by the client events.
+type connectionStatus struct {
+	lock      sync.Mutex
+	client    mqttClient // the session followed, callbacks of older ones are ignored
+	connected bool       // connected at least once
+	attempts  int
+	lastErr   error
+
+	label *widget.Label
+}
+
+func newConnectionStatus() *connectionStatus {
+	s := &connectionStatus{label: widget.NewLabel("Offline")}
+	s.label.Importance = widget.LowImportance
+	s.label.Truncation = fyne.TextTruncateEllipsis
+	return s
+}
+
+func (s *connectionStatus) show(text string, importance widget.Importance) {
+	s.label.Importance = importance
+	s.label.SetText(text)
+}
+
+// follow starts reporting a new session.
+func (s *connectionStatus) follow(client mqttClient) {
+	s.lock.Lock()
+	s.client, s.connected, s.attempts, s.lastErr = client, false, 0, nil
+	s.lock.Unlock()
+
+	s.show("Connecting", widget.LowImportance)
+}
+
+// stopped reports the session as closed by the user.
+func (s *connectionStatus) stopped() {
+	s.lock.Lock()
+	s.client = nil
+	s.lock.Unlock()
+
+	s.show("Offline", widget.LowImportance)
+}
+
+// connectionEvents returns the callbacks updating the status and the cards.
+func (app *application) connectionEvents() connectionEvents {
+	status := app.status
+
+	var events connectionEvents
+	events.connected = func(client mqttClient) {
+		status.lock.Lock()
+		if status.client != client {
+			status.lock.Unlock()
+			return
+		}
+		reconnected := status.connected
+		status.connected, status.attempts, status.lastErr = true, 0, nil
+		status.lock.Unlock()
+
+		status.show("Connected", widget.SuccessImportance)
+		if reconnected && app.conn.transition(stateReconnecting, stateLive) {
+			app.resumeCards(client)
+		}
+	}
+
+	events.lost = func(client mqttClient, err error) {
+		status.lock.Lock()
+		if status.client != client {
+			status.lock.Unlock()
+			return
+		}
+		status.lastErr = err
+		status.lock.Unlock()
+
+		status.show("Offline: "+err.Error(), widget.DangerImportance)
+
+		// The setup would wait forever for subscriptions lost with the connection
+		if app.conn.fail(fmt.Errorf("connection lost while setting up: %w", err)) {
+			return
+		}
+		if !app.conn.transition(stateLive, stateReconnecting) {
+			return
+		}
+		for _, card := range app.cardList() {
+			card.Disable()
+		}
+	}
+
+	events.reconnecting = func(client mqttClient) {
+		status.lock.Lock()
+		if status.client != client {
+			status.lock.Unlock()
+			return
+		}
+		status.attempts++
+		text := fmt.Sprintf("Reconnecting (attempt %d)", status.attempts)
+		if status.lastErr != nil {
+			text += ", last error: " + status.lastErr.Error()
+		}
+		status.lock.Unlock()
+
+		status.show(text, widget.WarningImportance)
+	}
+
+	return events
+}
+
+// resumeCards brings the cards back after an automatic reconnection. Without
+// a persisted session the broker forgot our subscriptions, so the cards are
+// bound again.
+func (app *application) resumeCards(client mqttClient) {
+	resubscribe := client.CleanSession()
+
+	for _, card := range app.cardList() {
+		if !resubscribe {
+			card.Enable()
+			continue
+		}
+
+		card.disconnect()
+		if err := app.bindCard(card, func() {}); err != nil {
+			fyne.LogError("Unable to subscribe again to "+stationTitle(card.serial), err)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+)
+
+var (
+	storeRetentionKey     = "storeRetention"
+	defaultStoreRetention = 30
+)
+
+const (
+	storeDayLayout   = "2006-01-02"
+	storeExtension   = ".jsonl"
+	storeCompactedGz = ".jsonl.gz"
+)
+
+type observationRecord struct {
+	Time    time.Time       `json:"time"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// observationStore appends the received observations to one JSON Lines file
+// per station and per day. Past days are compressed and removed once older
+// than the retention.
+type observationStore struct {
+	dir string
+	now func() time.Time
+
+	lock      sync.Mutex
+	retention int // days
+	today     string
+}
+
+func newObservationStore(dir string, retention int) *observationStore {
+	return &observationStore{dir: dir, now: time.Now, retention: retention}
+}
+
+func (s *observationStore) stationDir(serial string) string {
+	return filepath.Join(s.dir, "ST-"+serial)
+}
+
+func (s *observationStore) append(serial string, t time.Time, payload string) error {
+	if !json.Valid([]byte(payload)) {
+		return errors.New("observation is not valid JSON")
+	}
+
+	line, err := json.Marshal(observationRecord{Time: t, Payload: json.RawMessage(payload)})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	day := t.Local().Format(storeDayLayout)
+	if s.today != day {
+		if s.today != "" {
+			go s.compact()
+		}
+		s.today = day
+	}
+
+	dir := s.stationDir(serial)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, day+storeExtension), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// stations returns the serials of the stations with recorded observations.
+func (s *observationStore) stations() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serials := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), "ST-") {
+			serials = append(serials, strings.TrimPrefix(entry.Name(), "ST-"))
+		}
+	}
+	return serials, nil
+}
+
+// read returns the observations of a station recorded between from and to, in time order.
+func (s *observationStore) read(serial string, from, to time.Time) ([]observationRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := []observationRecord{}
+	dir := s.stationDir(serial)
+
+	start := from.Local()
+	for day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local); !day.After(to); day = day.AddDate(0, 0, 1) {
+		name := day.Format(storeDayLayout)
+
+		for _, ext := range []string{storeCompactedGz, storeExtension} {
+			dayRecords, err := readRecords(filepath.Join(dir, name+ext))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return records, err
+			}
+
+			for _, r := range dayRecords {
+				if !r.Time.Before(from) && !r.Time.After(to) {
+					records = append(records, r)
+				}
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func readRecords(path string) ([]observationRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	records := []observationRecord{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record observationRecord
+		// Skip a line truncated by a crash rather than losing the whole day
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+// compact compresses the files of the past days and removes the ones older than the retention.
+func (s *observationStore) compact() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	today := now.Format(storeDayLayout)
+	oldest := now.AddDate(0, 0, -s.retention).Format(storeDayLayout)
+
+	stations, err := os.ReadDir(s.dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fyne.LogError("Unable to list stored observations", err)
+		}
+		return
+	}
+
+	for _, station := range stations {
+		if !station.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, station.Name())
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			fyne.LogError("Unable to list stored observations", err)
+			continue
+		}
+
+		for _, file := range files {
+			name := file.Name()
+			day := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), storeExtension)
+			path := filepath.Join(dir, name)
+
+			switch {
+			case day < oldest:
+				err = os.Remove(path)
+			case day < today && strings.HasSuffix(name, storeExtension):
+				err = compressFile(path)
+			default:
+				continue
+			}
+			if err != nil {
+				fyne.LogError("Unable to compact "+path, err)
+			}
+		}
+	}
+}
+
+// compressFile moves a file to its .gz, appending a gzip member when
+// observations of that day were already compressed.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if info.Size() == 0 {
+			os.Remove(path + ".gz")
+		} else {
+			os.Truncate(path+".gz", info.Size())
+		}
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func (s *observationStore) setRetention(days int) {
+	s.lock.Lock()
+	s.retention = days
+	s.lock.Unlock()
+
+	go s.compact()
+}
+
+func (app *application) storeRetention() int {
+	return app.app.Preferences().IntWithFallback(storeRetentionKey, defaultStoreRetention)
+}
+
+func (app *application) storeFormItems() ([]*widget.FormItem, func()) {
+	retention := widget.NewSelect([]string{"7", "30", "90", "365"}, nil)
+	retention.SetSelected(strconv.Itoa(app.storeRetention()))
+
+	return []*widget.FormItem{{Text: "Keep on disk", Widget: retention, HintText: "Days of observations stored locally"}}, func() {
+		days, err := strconv.Atoi(retention.Selected)
+		if err != nil {
+			fyne.LogError("Invalid storage retention", err)
+			return
+		}
+		app.app.Preferences().SetInt(storeRetentionKey, days)
+		app.store.setRetention(days)
+	}
+}
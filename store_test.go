@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestStore returns a store in a temporary directory whose clock is stopped at now.
+func newTestStore(t *testing.T, now time.Time, retention int) *observationStore {
+	s := newObservationStore(t.TempDir(), retention)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+// writeStoredFile writes one observation per time to a file of the station.
+func writeStoredFile(t *testing.T, s *observationStore, name string, times ...time.Time) {
+	t.Helper()
+
+	dir := s.stationDir("1234")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{}
+	for _, at := range times {
+		line, err := json.Marshal(observationRecord{Time: at, Payload: json.RawMessage(fmt.Sprintf(`{"time":%d}`, at.Unix()))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeCompressedFile writes the observations of a day already compacted.
+func writeCompressedFile(t *testing.T, s *observationStore, day string, times ...time.Time) {
+	t.Helper()
+
+	writeStoredFile(t, s, day+storeExtension, times...)
+	if err := compressFile(filepath.Join(s.stationDir("1234"), day+storeExtension)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storedFiles(t *testing.T, s *observationStore) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(s.stationDir("1234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func recordTimes(records []observationRecord) []time.Time {
+	times := []time.Time{}
+	for _, r := range records {
+		times = append(times, r.Time)
+	}
+	return times
+}
+
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func at(day, hour int) time.Time {
+	return time.Date(2024, time.June, day, hour, 0, 0, 0, time.Local)
+}
+
+func TestObservationStoreCompact(t *testing.T) {
+	s := newTestStore(t, at(15, 12), 30)
+
+	writeStoredFile(t, s, "2024-05-10.jsonl", time.Date(2024, time.May, 10, 8, 0, 0, 0, time.Local))
+	writeCompressedFile(t, s, "2024-05-12", time.Date(2024, time.May, 12, 8, 0, 0, 0, time.Local))
+	writeStoredFile(t, s, "2024-06-12.jsonl", at(12, 8), at(12, 20))
+	writeCompressedFile(t, s, "2024-06-14", at(14, 1), at(14, 2))
+	// Observations of a compacted day received late
+	writeStoredFile(t, s, "2024-06-14.jsonl", at(14, 23))
+	writeStoredFile(t, s, "2024-06-15.jsonl", at(15, 6), at(15, 11))
+
+	s.compact()
+
+	want := []string{"2024-06-12.jsonl.gz", "2024-06-14.jsonl.gz", "2024-06-15.jsonl"}
+	if got := storedFiles(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after compacting %v, want %v", got, want)
+	}
+
+	records, err := s.read("1234", at(1, 0), at(15, 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTimes := []time.Time{at(12, 8), at(12, 20), at(14, 1), at(14, 2), at(14, 23), at(15, 6), at(15, 11)}
+	if got := recordTimes(records); !sameTimes(got, wantTimes) {
+		t.Errorf("records after compacting at %v, want %v", got, wantTimes)
+	}
+	if string(records[4].Payload) != fmt.Sprintf(`{"time":%d}`, at(14, 23).Unix()) {
+		t.Errorf("payload of the late observation %s", records[4].Payload)
+	}
+
+	// Compacting again changes nothing
+	s.compact()
+	if got := storedFiles(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("files after compacting twice %v, want %v", got, want)
+	}
+}
+
+func TestObservationStoreRetention(t *testing.T) {
+	s := newTestStore(t, at(15, 12), 30)
+	writeCompressedFile(t, s, "2024-06-10", at(10, 8))
+	writeCompressedFile(t, s, "2024-06-12", at(12, 8))
+	writeStoredFile(t, s, "2024-06-13.jsonl", at(13, 8))
+	writeStoredFile(t, s, "2024-06-15.jsonl", at(15, 8))
+
+	s.setRetention(2)
+	s.compact()
+
+	want := []string{"2024-06-13.jsonl.gz", "2024-06-15.jsonl"}
+	if got := storedFiles(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("files kept for 2 days %v, want %v", got, want)
+	}
+}
+
+func TestObservationStoreRead(t *testing.T) {
+	s := newTestStore(t, at(15, 12), 30)
+	writeCompressedFile(t, s, "2024-06-13", at(13, 8), at(13, 20))
+	writeCompressedFile(t, s, "2024-06-14", at(14, 4))
+	writeStoredFile(t, s, "2024-06-14.jsonl", at(14, 6), at(14, 22))
+	writeStoredFile(t, s, "2024-06-15.jsonl", at(15, 6), at(15, 11))
+
+	for _, tt := range []struct {
+		from, to time.Time
+		want     []time.Time
+	}{
+		{at(13, 0), at(15, 12), []time.Time{at(13, 8), at(13, 20), at(14, 4), at(14, 6), at(14, 22), at(15, 6), at(15, 11)}},
+		{at(13, 12), at(15, 8), []time.Time{at(13, 20), at(14, 4), at(14, 6), at(14, 22), at(15, 6)}},
+		{at(14, 4), at(14, 6), []time.Time{at(14, 4), at(14, 6)}},
+		{at(14, 23), at(15, 5), []time.Time{}},
+		{at(1, 0), at(2, 0), []time.Time{}},
+	} {
+		records, err := s.read("1234", tt.from, tt.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := recordTimes(records); !sameTimes(got, tt.want) {
+			t.Errorf("read from %s to %s gives %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if records, err := s.read("5678", at(13, 0), at(15, 12)); err != nil || len(records) != 0 {
+		t.Errorf("read of an unknown station gives %v, %v", records, err)
+	}
+}
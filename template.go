@@ -0,0 +1,557 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Home Assistant value templates are Jinja expressions. Only a small sandboxed
+// subset is supported: the value and value_json variables, literals,
+// arithmetic and the round, float, int, default and abs filters.
+
+const maxTemplateLength = 1024
+
+// undefined is the value of a missing variable or JSON item.
+type undefined struct{}
+
+type templateNode interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+// valueTemplate is a compiled value template, made of text and {{ }} expressions.
+type valueTemplate struct {
+	parts []templateNode
+}
+
+type textNode string
+
+func (n textNode) eval(map[string]interface{}) (interface{}, error) { return string(n), nil }
+
+type literalNode struct{ value interface{} }
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type variableNode string
+
+func (n variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	if v, ok := vars[string(n)]; ok {
+		return v, nil
+	}
+	return undefined{}, nil
+}
+
+// itemNode reads an attribute or an item, value_json.a or value_json["a"] or value_json[0].
+type itemNode struct {
+	target templateNode
+	key    templateNode
+}
+
+func (n itemNode) eval(vars map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := target.(type) {
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			if v, ok := t[k]; ok {
+				return v, nil
+			}
+		}
+	case []interface{}:
+		if k, ok := key.(float64); ok && k == math.Trunc(k) && int(k) >= 0 && int(k) < len(t) {
+			return t[int(k)], nil
+		}
+	}
+	return undefined{}, nil
+}
+
+type unaryNode struct{ operand templateNode }
+
+func (n unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	f, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	return -f, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right templateNode
+}
+
+func (n binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "+" {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			return ls + rs, nil
+		}
+	}
+
+	a, err := toNumber(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toNumber(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "**":
+		return math.Pow(a, b), nil
+	}
+
+	if b == 0 {
+		return nil, errors.New("division by zero")
+	}
+	switch n.op {
+	case "/":
+		return a / b, nil
+	case "//":
+		return math.Floor(a / b), nil
+	}
+	return math.Mod(a, b), nil
+}
+
+type filterNode struct {
+	name   string
+	target templateNode
+	args   []templateNode
+}
+
+func (n filterNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		if args[i], err = arg.eval(vars); err != nil {
+			return nil, err
+		}
+	}
+	arg := func(i int, fallback interface{}) interface{} {
+		if i < len(args) {
+			return args[i]
+		}
+		return fallback
+	}
+
+	switch n.name {
+	case "default", "d":
+		if _, missing := v.(undefined); missing {
+			return arg(0, ""), nil
+		}
+		return v, nil
+	case "float":
+		f, err := toNumber(v)
+		if err != nil {
+			return arg(0, 0.0), nil
+		}
+		return f, nil
+	case "int":
+		f, err := toNumber(v)
+		if err != nil {
+			return arg(0, 0.0), nil
+		}
+		return math.Trunc(f), nil
+	case "abs":
+		f, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		return math.Abs(f), nil
+	case "round":
+		f, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		precision, err := toNumber(arg(0, 0.0))
+		if err != nil {
+			return nil, err
+		}
+		scale := math.Pow(10, math.Trunc(precision))
+		switch method := arg(1, "common"); method {
+		case "floor":
+			return math.Floor(f*scale) / scale, nil
+		case "ceil":
+			return math.Ceil(f*scale) / scale, nil
+		}
+		return math.Round(f*scale) / scale, nil
+	}
+
+	return nil, fmt.Errorf("unknown filter %q", n.name)
+}
+
+// toNumber converts a template value for arithmetic like the float filter does.
+func toNumber(v interface{}) (float64, error) {
+	switch t := v.(type) {
+	case float64:
+		return t, nil
+	case bool:
+		if t {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(t), 64)
+	case undefined:
+		return 0, errors.New("undefined value")
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// compileValueTemplate parses a value template.
+func compileValueTemplate(source string) (*valueTemplate, error) {
+	if len(source) > maxTemplateLength {
+		return nil, errors.New("value template is too long")
+	}
+
+	t := &valueTemplate{}
+	for rest := source; rest != ""; {
+		start := strings.Index(rest, "{{")
+		text := rest
+		if start >= 0 {
+			text = rest[:start]
+		}
+		// Statements and comments would be rendered as text
+		if strings.Contains(text, "{%") {
+			return nil, errors.New("{% statements are not supported in value template")
+		}
+		if strings.Contains(text, "{#") {
+			return nil, errors.New("{# comments are not supported in value template")
+		}
+
+		if start < 0 {
+			t.parts = append(t.parts, textNode(rest))
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, textNode(text))
+		}
+
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, errors.New("unclosed {{ in value template")
+		}
+		node, err := parseTemplateExpression(rest[start+2 : start+end])
+		if err != nil {
+			return nil, err
+		}
+		t.parts = append(t.parts, node)
+		rest = rest[start+end+2:]
+	}
+
+	return t, nil
+}
+
+// render evaluates the template for an MQTT payload. A template made of a
+// single expression returns its value, otherwise the text of all the parts.
+func (t *valueTemplate) render(payload string) (interface{}, error) {
+	vars := map[string]interface{}{"value": payload}
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(payload), &parsed); err == nil {
+		vars["value_json"] = parsed
+	}
+
+	if len(t.parts) == 1 {
+		v, err := t.parts[0].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		if _, missing := v.(undefined); missing {
+			return nil, errors.New("undefined value")
+		}
+		return v, nil
+	}
+
+	var text strings.Builder
+	for _, part := range t.parts {
+		v, err := part.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch t := v.(type) {
+		case undefined:
+		case float64:
+			text.WriteString(strconv.FormatFloat(t, 'f', -1, 64))
+		default:
+			fmt.Fprint(&text, t)
+		}
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// templateParser is a recursive descent parser of template expressions.
+type templateParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseTemplateExpression(source string) (templateNode, error) {
+	tokens, err := tokenizeTemplate(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &templateParser{tokens: tokens}
+	node, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in value template", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+func tokenizeTemplate(source string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(source[i+1:], c)
+			if end < 0 {
+				return nil, errors.New("unclosed string in value template")
+			}
+			tokens = append(tokens, source[i:i+end+2])
+			i += end + 2
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(source) && (source[j] >= '0' && source[j] <= '9' || source[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(source) && (source[j] == '_' || source[j] >= 'a' && source[j] <= 'z' || source[j] >= 'A' && source[j] <= 'Z' || source[j] >= '0' && source[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		case strings.HasPrefix(source[i:], "**"), strings.HasPrefix(source[i:], "//"):
+			tokens = append(tokens, source[i:i+2])
+			i += 2
+		case strings.IndexByte("+-*/%|().,[]", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q in value template", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *templateParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *templateParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *templateParser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("expected %q instead of %q in value template", token, t)
+	}
+	return nil
+}
+
+func (p *templateParser) additive() (templateNode, error) {
+	left, err := p.multiplicative()
+	for err == nil && (p.peek() == "+" || p.peek() == "-") {
+		op := p.next()
+		var right templateNode
+		if right, err = p.multiplicative(); err == nil {
+			left = binaryNode{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *templateParser) multiplicative() (templateNode, error) {
+	left, err := p.power()
+	for err == nil && (p.peek() == "*" || p.peek() == "/" || p.peek() == "//" || p.peek() == "%") {
+		op := p.next()
+		var right templateNode
+		if right, err = p.power(); err == nil {
+			left = binaryNode{op: op, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+// power parses ** from left to right on signed operands, so -2 ** 2 is 4 like in Jinja.
+func (p *templateParser) power() (templateNode, error) {
+	left, err := p.unary(true)
+	for err == nil && p.peek() == "**" {
+		p.next()
+		var right templateNode
+		if right, err = p.unary(true); err == nil {
+			left = binaryNode{op: "**", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+// unary parses a signed value followed by its filters, which bind tighter
+// than arithmetic but apply to the sign like in Jinja.
+func (p *templateParser) unary(filters bool) (templateNode, error) {
+	var node templateNode
+	var err error
+	switch p.peek() {
+	case "-":
+		p.next()
+		var operand templateNode
+		operand, err = p.unary(false)
+		node = unaryNode{operand}
+	case "+":
+		p.next()
+		node, err = p.unary(false)
+	default:
+		node, err = p.postfix()
+	}
+	if err != nil || !filters {
+		return node, err
+	}
+	return p.filtered(node)
+}
+
+// templateFilters are the filters a value template can use.
+var templateFilters = map[string]bool{"default": true, "d": true, "float": true, "int": true, "abs": true, "round": true}
+
+func (p *templateParser) filtered(node templateNode) (templateNode, error) {
+	var err error
+	for err == nil && p.peek() == "|" {
+		p.next()
+		name := p.next()
+		if !templateFilters[name] {
+			return nil, fmt.Errorf("unknown filter %q in value template", name)
+		}
+		filter := filterNode{name: name, target: node}
+		if p.peek() == "(" {
+			p.next()
+			for err == nil && p.peek() != ")" {
+				var arg templateNode
+				if arg, err = p.additive(); err == nil {
+					filter.args = append(filter.args, arg)
+					if p.peek() == "," {
+						p.next()
+					}
+				}
+			}
+			if err == nil {
+				err = p.expect(")")
+			}
+		}
+		node = filter
+	}
+	return node, err
+}
+
+func (p *templateParser) postfix() (templateNode, error) {
+	node, err := p.primary()
+	for err == nil {
+		switch p.peek() {
+		case ".":
+			p.next()
+			name := p.next()
+			if name == "" || !isTemplateIdentifier(name) && !isTemplateNumber(name) {
+				return nil, fmt.Errorf("unexpected %q after . in value template", name)
+			}
+			var key interface{} = name
+			if isTemplateNumber(name) {
+				key, _ = strconv.ParseFloat(name, 64)
+			}
+			node = itemNode{target: node, key: literalNode{key}}
+		case "[":
+			p.next()
+			var key templateNode
+			if key, err = p.additive(); err == nil {
+				err = p.expect("]")
+			}
+			node = itemNode{target: node, key: key}
+		default:
+			return node, nil
+		}
+	}
+	return node, err
+}
+
+func (p *templateParser) primary() (templateNode, error) {
+	t := p.next()
+	switch {
+	case t == "(":
+		node, err := p.additive()
+		if err == nil {
+			err = p.expect(")")
+		}
+		return node, err
+	case t == "":
+		return nil, errors.New("unexpected end of value template")
+	case t[0] == '\'' || t[0] == '"':
+		return literalNode{t[1 : len(t)-1]}, nil
+	case isTemplateNumber(t):
+		f, err := strconv.ParseFloat(t, 64)
+		return literalNode{f}, err
+	case t == "true" || t == "True":
+		return literalNode{true}, nil
+	case t == "false" || t == "False":
+		return literalNode{false}, nil
+	case t == "none" || t == "None":
+		return literalNode{nil}, nil
+	case t == "value" || t == "value_json":
+		return variableNode(t), nil
+	case isTemplateIdentifier(t):
+		return nil, fmt.Errorf("unknown variable %q in value template", t)
+	}
+	return nil, fmt.Errorf("unexpected %q in value template", t)
+}
+
+func isTemplateNumber(t string) bool {
+	return t != "" && t[0] >= '0' && t[0] <= '9'
+}
+
+func isTemplateIdentifier(t string) bool {
+	return t != "" && (t[0] == '_' || t[0] >= 'a' && t[0] <= 'z' || t[0] >= 'A' && t[0] <= 'Z')
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValueTemplateRender(t *testing.T) {
+	for _, tt := range []struct {
+		template, payload string
+		want              interface{}
+	}{
+		{"{{ value }}", "21.5", "21.5"},
+		{"{{ value }} hPa", "1013", "1013 hPa"},
+		{`{{ value_json.a[0]["b"] }}`, `{"a":[{"b":3}]}`, 3.0},
+		{"{{ value_json['a'].1 }}", `{"a":[1,2]}`, 2.0},
+		{"{{ value_json.t / 10 }}", `{"t":215}`, 21.5},
+
+		// Precedence follows Jinja
+		{"{{ 1 + 2 * 3 }}", "", 7.0},
+		{"{{ (1 + 2) * 3 }}", "", 9.0},
+		{"{{ 10 - 4 - 3 }}", "", 3.0},
+		{"{{ -2 ** 2 }}", "", 4.0},
+		{"{{ 2 ** 3 ** 2 }}", "", 64.0},
+		{"{{ 2 * 3 ** 2 }}", "", 18.0},
+		{"{{ 2 * value | int }}", "3.7", 6.0},
+		{"{{ 1 - 2 | abs }}", "", -1.0},
+		{"{{ -value | abs }}", "3", 3.0},
+		{"{{ 7 // 2 }}", "", 3.0},
+		{"{{ 7 % 4 }}", "", 3.0},
+		{"{{ 'a' + 'b' }}", "", "ab"},
+
+		// Filters
+		{"{{ value | float }}", "21.5", 21.5},
+		{"{{ value | float }}", "n/a", 0.0},
+		{"{{ value | float(-1) }}", "n/a", -1.0},
+		{"{{ value | int }}", "-3.9", -3.0},
+		{"{{ value | int(-1) }}", "n/a", -1.0},
+		{"{{ value | float | round }}", "21.46", 21.0},
+		{"{{ value | float | round(1) }}", "21.46", 21.5},
+		{"{{ value | round(1, 'floor') }}", "21.46", 21.4},
+		{"{{ value | round(0, 'ceil') }}", "21.46", 22.0},
+		{"{{ value | abs }}", "-4", 4.0},
+		{"{{ value_json.missing | default(5) }}", "{}", 5.0},
+		{"{{ value_json.t | d(5) }}", `{"t":1}`, 1.0},
+		{"{{ value_json.missing | default }}", "{}", ""},
+	} {
+		template, err := compileValueTemplate(tt.template)
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+			continue
+		}
+		if got, err := template.render(tt.payload); err != nil || got != tt.want {
+			t.Errorf("%s with %q = %v (%v), want %v", tt.template, tt.payload, got, err, tt.want)
+		}
+	}
+}
+
+func TestValueTemplateRenderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		template, payload string
+	}{
+		{"{{ 1 / 0 }}", ""},
+		{"{{ 1 // 0 }}", ""},
+		{"{{ 1 % 0 }}", ""},
+		{"{{ value / (value - 2) }}", "2"},
+		{"{{ value_json.missing }}", "{}"},
+		{"{{ value_json.missing + 1 }}", "{}"},
+		{"{{ value_json[3] }}", "[1]"},
+		{"{{ value * 2 }}", "n/a"},
+		{"{{ value | round }}", "n/a"},
+	} {
+		template, err := compileValueTemplate(tt.template)
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+			continue
+		}
+		if got, err := template.render(tt.payload); err == nil {
+			t.Errorf("%s with %q = %v, want an error", tt.template, tt.payload, got)
+		}
+	}
+}
+
+func TestCompileValueTemplateErrors(t *testing.T) {
+	if _, err := compileValueTemplate(strings.Repeat("x", maxTemplateLength)); err != nil {
+		t.Errorf("template of the maximum length refused: %v", err)
+	}
+
+	for _, template := range []string{
+		strings.Repeat("x", maxTemplateLength+1),
+		"{{ value",
+		"{{ value }} and {{ value",
+		"{{ value | }}",
+		"{{ value | timestamp_local }}",
+		"{{ value | float(0 }}",
+		"{{ 'abc }}",
+		"{{ states('sensor.x') }}",
+		"{{ value ) }}",
+		"{{ value. }}",
+		"{{ 1 + }}",
+		"{{ value; }}",
+		"{{ }}",
+		"{% if value %}{{ value }}{% endif %}",
+		"{% set x = 1 %}",
+		"{{ value }}{# the temperature #}",
+		"{#- comment -#}",
+		"temperature {%- if true %}",
+	} {
+		if _, err := compileValueTemplate(template); err == nil {
+			t.Errorf("%.40s compiled, want an error", template)
+		}
+	}
+}
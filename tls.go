@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+)
+
+type tlsSettings struct {
+	CA       string
+	Cert     string
+	Key      string
+	Insecure bool
+}
+
+// isSecureBroker reports whether the broker URL asks for a TLS connection.
+func isSecureBroker(broker string) bool {
+	for _, scheme := range []string{"ssl://", "tls://", "wss://"} {
+		if strings.HasPrefix(broker, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// config builds the TLS configuration handed to paho, loading the CA bundle and
+// the client certificate and key from disk when they are set.
+func (s tlsSettings) config() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: s.Insecure}
+
+	if s.CA != "" {
+		pem, err := os.ReadFile(s.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificate found in " + s.CA)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if s.Cert != "" || s.Key != "" {
+		if s.Cert == "" || s.Key == "" {
+			return nil, errors.New("client certificate and key must be provided together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(s.Cert, s.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// testAuthority is a self-signed CA issuing the certificates of the broker stand-in and its clients.
+type testAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM of the CA certificate
+}
+
+func newTestAuthority(t *testing.T, dir, name string) *testAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &testAuthority{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate and its key signed by the CA, returning their files.
+func (ca *testAuthority) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDer)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listenTLSBroker starts a stand-in of a Mosquitto broker requiring client
+// certificates of the CA, accepting every MQTT 3.1.1 connection.
+func listenTLSBroker(t *testing.T, ca *testAuthority, certFile, keyFile string) string {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := x509.NewCertPool()
+	clients.AddCert(ca.cert)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConnack(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// serveConnack accepts the CONNECT packet, then drains the connection until the client leaves.
+func serveConnack(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 1)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0]>>4 != 1 {
+		return
+	}
+	length, multiplier := 0, 1
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length += int(header[0]&0x7f) * multiplier
+		if header[0]&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(length)); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{0x20, 0x02, 0x00, 0x00}); err != nil {
+		return
+	}
+	io.Copy(io.Discard, conn)
+}
+
+func connectTLS(addr string, cfg *tls.Config) error {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker("ssl://" + addr)
+	opts.SetClientID("tls-test")
+	opts.SetTLSConfig(cfg)
+	opts.SetConnectTimeout(5 * time.Second)
+	opts.AutoReconnect = false
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+	client.Disconnect(0)
+	return nil
+}
+
+func TestTLSSettingsConnect(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestAuthority(t, dir, "ca")
+	other := newTestAuthority(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	strangerCert, strangerKey := other.issue(t, dir, "stranger", x509.ExtKeyUsageClientAuth)
+	addr := listenTLSBroker(t, ca, serverCert, serverKey)
+
+	for name, tt := range map[string]struct {
+		settings tlsSettings
+		ok       bool
+	}{
+		"mutual TLS":                  {tlsSettings{CA: ca.file, Cert: clientCert, Key: clientKey}, true},
+		"wrong CA":                    {tlsSettings{CA: other.file, Cert: clientCert, Key: clientKey}, false},
+		"system CA":                   {tlsSettings{Cert: clientCert, Key: clientKey}, false},
+		"insecure":                    {tlsSettings{Cert: clientCert, Key: clientKey, Insecure: true}, true},
+		"insecure with wrong CA":      {tlsSettings{CA: other.file, Cert: clientCert, Key: clientKey, Insecure: true}, true},
+		"no client certificate":       {tlsSettings{CA: ca.file}, false},
+		"client certificate of other": {tlsSettings{CA: ca.file, Cert: strangerCert, Key: strangerKey}, false},
+	} {
+		cfg, err := tt.settings.config()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if err := connectTLS(addr, cfg); (err == nil) != tt.ok {
+			t.Errorf("%s: connect error %v, want success %v", name, err, tt.ok)
+		}
+	}
+}
+
+func TestTLSSettingsConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestAuthority(t, dir, "ca")
+	clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth)
+	notPEM := filepath.Join(dir, "not.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, settings := range map[string]tlsSettings{
+		"certificate without key": {CA: ca.file, Cert: clientCert},
+		"key without certificate": {CA: ca.file, Key: clientKey},
+		"key of the certificate":  {Cert: clientKey, Key: clientKey},
+		"missing CA":              {CA: filepath.Join(dir, "missing.pem")},
+		"CA without certificate":  {CA: notPEM},
+		"missing certificate":     {Cert: filepath.Join(dir, "missing.pem"), Key: clientKey},
+	} {
+		if _, err := settings.config(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	cfg, err := tlsSettings{Insecure: true}.config()
+	if err != nil || !cfg.InsecureSkipVerify || cfg.RootCAs != nil || len(cfg.Certificates) != 0 {
+		t.Errorf("insecure settings give %+v, %v", cfg, err)
+	}
+}
+
+func TestIsSecureBroker(t *testing.T) {
+	for _, tt := range []struct {
+		broker string
+		want   bool
+	}{
+		{"ssl://broker.local:8883/", true},
+		{"tls://broker.local:8883/", true},
+		{"wss://broker.local:443/", true},
+		{"tcp://broker.local:1883/", false},
+		{"ws://broker.local:80/", false},
+		{"broker.local:8883", false},
+		{"tcp://ssl.local:1883/", false},
+	} {
+		if got := isSecureBroker(tt.broker); got != tt.want {
+			t.Errorf("isSecureBroker(%q) = %v, want %v", tt.broker, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultDiscoveryTopic   = "{prefix}/sensor/+/status/attributes"
+	defaultSerialPattern    = `weatherflow2mqtt_ST-(\d+)`
+	defaultObservationTopic = "{prefix}/sensor/weatherflow2mqtt_ST-{serial}/observation/state"
+	defaultStatusTopic      = "{prefix}/sensor/weatherflow2mqtt_ST-{serial}/status/attributes"
+
+	// previewSerial stands for the station serial in the topic previews
+	previewSerial = "12345"
+)
+
+// topicTemplates are the topics of a profile, {prefix} is replaced by the
+// topic prefix and {serial} by the serial of the station. Empty ones use the
+// weatherflow2mqtt topics.
+type topicTemplates struct {
+	Discovery     string `json:"discovery,omitempty"`
+	SerialPattern string `json:"serialPattern,omitempty"` // regexp of whole topic levels whose first group is the serial
+	Observation   string `json:"observation,omitempty"`
+	Status        string `json:"status,omitempty"`
+}
+
+// stationTopics are the templates of a profile resolved for its prefix.
+type stationTopics struct {
+	discovery   string
+	serial      *regexp.Regexp
+	observation string
+	status      string
+}
+
+func (t stationTopics) observationTopic(serial string) string {
+	return strings.ReplaceAll(t.observation, "{serial}", serial)
+}
+
+func (t stationTopics) statusTopic(serial string) string {
+	return strings.ReplaceAll(t.status, "{serial}", serial)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// topics resolves and validates the topic templates of the profile.
+func (p connectionProfile) topics() (stationTopics, error) {
+	prefix := func(s string) string { return strings.ReplaceAll(s, "{prefix}", p.topicPrefix()) }
+
+	t := stationTopics{
+		discovery:   prefix(orDefault(p.Topics.Discovery, defaultDiscoveryTopic)),
+		observation: prefix(orDefault(p.Topics.Observation, defaultObservationTopic)),
+		status:      prefix(orDefault(p.Topics.Status, defaultStatusTopic)),
+	}
+
+	if err := validateTopicFilter(t.discovery); err != nil {
+		return t, fmt.Errorf("discovery topic: %w", err)
+	}
+	serial, err := compileSerialPattern(orDefault(p.Topics.SerialPattern, defaultSerialPattern))
+	if err != nil {
+		return t, err
+	}
+	t.serial = serial
+	if err := validateTopicTemplate(t.observation); err != nil {
+		return t, fmt.Errorf("observation topic: %w", err)
+	}
+	if err := validateTopicTemplate(t.status); err != nil {
+		return t, fmt.Errorf("status topic: %w", err)
+	}
+
+	return t, nil
+}
+
+// validateTopicFilter checks the wildcards of a subscription: + and # must
+// take a whole level and # can only be the last one.
+func validateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("topic is empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return errors.New("# must be the last level")
+		case level != "+" && level != "#" && strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("wildcard inside the level %q", level)
+		}
+	}
+	return nil
+}
+
+// validateTopicTemplate checks a per station topic, which needs {serial} and no wildcard.
+func validateTopicTemplate(template string) error {
+	if template == "" {
+		return errors.New("topic is empty")
+	}
+	if !strings.Contains(template, "{serial}") {
+		return errors.New("{serial} is missing")
+	}
+	if strings.ContainsAny(template, "+#") {
+		return errors.New("wildcards are not allowed")
+	}
+	return nil
+}
+
+// validateFieldTopic checks the topic of one field, which gives a single
+// value and so cannot be a wildcard subscription.
+func validateFieldTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic is empty")
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return errors.New("wildcards are not allowed")
+	}
+	return nil
+}
+
+// compileSerialPattern returns the pattern anchored to whole topic levels, so
+// ST-(\d+) matches site/ST-1234/status but not site/MY-ST-1234/status.
+func compileSerialPattern(pattern string) (*regexp.Regexp, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("serial pattern: %w", err)
+	}
+	if r.NumSubexp() < 1 {
+		return nil, errors.New("serial pattern: a group capturing the serial is missing")
+	}
+	return regexp.Compile(`(?:^|/)(?:` + pattern + `)(?:/|$)`)
+}
+
+// preview describes the topics the profile subscribes to.
+func (p connectionProfile) preview() string {
+	t, err := p.topics()
+	if err != nil {
+		return "⚠ " + err.Error()
+	}
+
+	serial := previewSerial
+	if len(p.Stations) > 0 {
+		serial = p.Stations[0]
+	}
+	return "Discovery: " + t.discovery + "\nObservation: " + t.observationTopic(serial) + "\nStatus: " + t.statusTopic(serial)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSerialPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, topic, serial string
+	}{
+		{defaultSerialPattern, "homeassistant/sensor/weatherflow2mqtt_ST-1234/status/attributes", "1234"},
+		{defaultSerialPattern, "homeassistant/sensor/weatherflow2mqtt_ST-1234x/status/attributes", ""},
+		{defaultSerialPattern, "homeassistant/sensor/my_weatherflow2mqtt_ST-1234/status/attributes", ""},
+		{`ST-(\d+)`, "site/ST-42/status", "42"},
+		{`ST-(\d+)`, "ST-42", "42"},
+		{`ST-(\d+)`, "site/MY-ST-42/status", ""},
+		{`ST-(\d+)`, "homeassistant/sensor/weatherflow2mqtt_ST-42/config", ""},
+		{`site/([^/]+)/status`, "bridge/site/garden/status", "garden"},
+		{`site/([^/]+)/status`, "bridge/site/garden/status/extra", "garden"},
+		{`site/([^/]+)/status`, "bridge/mysite/garden/status", ""},
+	} {
+		r, err := compileSerialPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+
+		serial := ""
+		if m := r.FindStringSubmatch(tt.topic); len(m) > 1 {
+			serial = m[1]
+		}
+		if serial != tt.serial {
+			t.Errorf("%s on %s: serial %q, want %q", tt.pattern, tt.topic, serial, tt.serial)
+		}
+	}
+
+	for _, pattern := range []string{`ST-\d+`, `ST-(\d+`} {
+		if _, err := compileSerialPattern(pattern); err == nil {
+			t.Errorf("%s: invalid pattern accepted", pattern)
+		}
+	}
+}
+
+func TestValidateTopicFilter(t *testing.T) {
+	for _, tt := range []struct {
+		filter string
+		ok     bool
+	}{
+		{"homeassistant/sensor/+/status/attributes", true},
+		{"site/ST-1234/status", true},
+		{"#", true},
+		{"+", true},
+		{"site/#", true},
+		{"+/+/status", true},
+		{"site//status", true}, // empty levels are valid topic levels
+		{"/site", true},
+		{"", false},
+		{"a/#/b", false},
+		{"#/status", false},
+		{"a+/b", false},
+		{"a/b+", false},
+		{"a/#b", false},
+		{"site/status#", false},
+	} {
+		if err := validateTopicFilter(tt.filter); (err == nil) != tt.ok {
+			t.Errorf("validateTopicFilter(%q) = %v, want valid %v", tt.filter, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateTopicTemplate(t *testing.T) {
+	for _, tt := range []struct {
+		template string
+		ok       bool
+	}{
+		{defaultObservationTopic, true},
+		{"site/ST-{serial}/obs", true},
+		{"{serial}", true},
+		{"", false},
+		{"site/station/obs", false},
+		{"site/+/ST-{serial}", false},
+		{"site/ST-{serial}/#", false},
+		{"site/ST-{serial}+", false},
+		{"site/{Serial}/obs", false},
+	} {
+		if err := validateTopicTemplate(tt.template); (err == nil) != tt.ok {
+			t.Errorf("validateTopicTemplate(%q) = %v, want valid %v", tt.template, err, tt.ok)
+		}
+	}
+}
+
+func TestProfileTopics(t *testing.T) {
+	for name, tt := range map[string]struct {
+		profile                        connectionProfile
+		discovery, observation, status string
+		announce                       string // discovery topic of the station 42
+		err                            string
+	}{
+		"defaults": {
+			profile:     connectionProfile{},
+			discovery:   "homeassistant/sensor/+/status/attributes",
+			observation: "homeassistant/sensor/weatherflow2mqtt_ST-42/observation/state",
+			status:      "homeassistant/sensor/weatherflow2mqtt_ST-42/status/attributes",
+			announce:    "homeassistant/sensor/weatherflow2mqtt_ST-42/status/attributes",
+		},
+		"prefix": {
+			profile:     connectionProfile{TopicPrefix: "ha"},
+			discovery:   "ha/sensor/+/status/attributes",
+			observation: "ha/sensor/weatherflow2mqtt_ST-42/observation/state",
+			status:      "ha/sensor/weatherflow2mqtt_ST-42/status/attributes",
+			announce:    "ha/sensor/weatherflow2mqtt_ST-42/status/attributes",
+		},
+		"custom": {
+			profile: connectionProfile{TopicPrefix: "home", Topics: topicTemplates{
+				Discovery:     "{prefix}/weather/+/status",
+				SerialPattern: `weather/([^/]+)/status`,
+				Observation:   "{prefix}/weather/{serial}/obs",
+				Status:        "{prefix}/weather/{serial}/status",
+			}},
+			discovery:   "home/weather/+/status",
+			observation: "home/weather/42/obs",
+			status:      "home/weather/42/status",
+			announce:    "home/weather/42/status",
+		},
+		"invalid discovery": {
+			profile: connectionProfile{Topics: topicTemplates{Discovery: "site/#/status"}},
+			err:     "discovery topic",
+		},
+		"invalid serial pattern": {
+			profile: connectionProfile{Topics: topicTemplates{SerialPattern: `ST-\d+`}},
+			err:     "serial pattern",
+		},
+		"observation without serial": {
+			profile: connectionProfile{Topics: topicTemplates{Observation: "site/obs"}},
+			err:     "observation topic",
+		},
+		"status with wildcard": {
+			profile: connectionProfile{Topics: topicTemplates{Status: "site/{serial}/+"}},
+			err:     "status topic",
+		},
+	} {
+		topics, err := tt.profile.topics()
+		if tt.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %s", name, err, tt.err)
+			}
+			if preview := tt.profile.preview(); !strings.HasPrefix(preview, "⚠ "+tt.err) {
+				t.Errorf("%s: preview %q does not warn about the %s", name, preview, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if topics.discovery != tt.discovery || topics.observationTopic("42") != tt.observation || topics.statusTopic("42") != tt.status {
+			t.Errorf("%s: topics %s, %s, %s", name, topics.discovery, topics.observationTopic("42"), topics.statusTopic("42"))
+		}
+		if !topicMatches(topics.discovery, tt.announce) {
+			t.Errorf("%s: %s does not match %s", name, topics.discovery, tt.announce)
+		}
+		if m := topics.serial.FindStringSubmatch(tt.announce); len(m) < 2 || m[1] != "42" {
+			t.Errorf("%s: serial not found in %s", name, tt.announce)
+		}
+
+		p := tt.profile
+		p.Stations = []string{"42"}
+		want := "Discovery: " + tt.discovery + "\nObservation: " + tt.observation + "\nStatus: " + tt.status
+		if preview := p.preview(); preview != want {
+			t.Errorf("%s: preview %q, want %q", name, preview, want)
+		}
+		if preview := tt.profile.preview(); !strings.Contains(preview, previewSerial) {
+			t.Errorf("%s: preview %q without a station does not use %s", name, preview, previewSerial)
+		}
+	}
+}
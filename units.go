@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	pressureSeaLevel = "Sea level"
+	pressureStation  = "Station"
+
+	unitSystemMetric   = "Metric"
+	unitSystemImperial = "Imperial"
+	unitSystemCustom   = "Custom"
+)
+
+// unitQuantity is a measured quantity that can be displayed in several units.
+type unitQuantity struct {
+	name     string
+	units    []string // the first unit is the one published by weatherflow2mqtt
+	imperial string
+	convert  func(v float64, unit string) float64
+
+	unit binding.String
+}
+
+// unitSettings holds the unit selected for each quantity, every binding
+// created through it follows the selection live.
+type unitSettings struct {
+	prefs fyne.Preferences
+
+	temperature *unitQuantity
+	speed       *unitQuantity
+	pressure    *unitQuantity
+	rain        *unitQuantity
+	distance    *unitQuantity
+
+	// pressureReference selects between sea-level and station pressure
+	pressureReference binding.String
+}
+
+var pressureReferenceKey = "pressureReference"
+
+func newUnitSettings(prefs fyne.Preferences) *unitSettings {
+	u := &unitSettings{prefs: prefs,
+		temperature: &unitQuantity{name: "Temperature", units: []string{"°C", "°F"}, imperial: "°F", convert: convertTemperature},
+		speed:       &unitQuantity{name: "Wind", units: []string{"kph", "mph", "m/s", "kn"}, imperial: "mph", convert: convertSpeed},
+		pressure:    &unitQuantity{name: "Pressure", units: []string{"hPa", "inHg", "mmHg"}, imperial: "inHg", convert: convertPressure},
+		rain:        &unitQuantity{name: "Rain", units: []string{"mm", "in"}, imperial: "in", convert: convertRain},
+		distance:    &unitQuantity{name: "Distance", units: []string{"km", "mi"}, imperial: "mi", convert: convertDistance},
+	}
+
+	for _, q := range u.quantities() {
+		q.unit = binding.NewString()
+		q.unit.Set(prefs.StringWithFallback(q.key(), q.units[0]))
+	}
+
+	u.pressureReference = binding.NewString()
+	u.pressureReference.Set(prefs.StringWithFallback(pressureReferenceKey, pressureSeaLevel))
+
+	return u
+}
+
+func (u *unitSettings) quantities() []*unitQuantity {
+	return []*unitQuantity{u.temperature, u.speed, u.pressure, u.rain, u.distance}
+}
+
+func (q *unitQuantity) key() string {
+	return "unit" + q.name
+}
+
+func (q *unitQuantity) set(unit string) {
+	q.unit.Set(unit)
+}
+
+func (q *unitQuantity) get() string {
+	unit, _ := q.unit.Get()
+	return unit
+}
+
+// convertFloat returns a binding of source, given in the first unit of the
+// quantity, converted to the selected unit. The listener added to the unit
+// setting is returned, to be removed once the binding is not used anymore.
+func (q *unitQuantity) convertFloat(source binding.Float) (binding.Float, binding.DataListener) {
+	converted := binding.NewFloat()
+
+	update := binding.NewDataListener(func() {
+		v, err := source.Get()
+		if err != nil {
+			return
+		}
+		converted.Set(q.convert(v, q.get()))
+	})
+	source.AddListener(update)
+	q.unit.AddListener(update)
+
+	return converted, update
+}
+
+// follow returns a copy of the selected unit, for bindings like
+// binding.NewSprintf that never remove their listeners. The listener added to
+// the unit setting is returned, to be removed once the copy is not used anymore.
+func (q *unitQuantity) follow() (binding.String, binding.DataListener) {
+	unit := binding.NewString()
+
+	update := binding.NewDataListener(func() {
+		unit.Set(q.get())
+	})
+	q.unit.AddListener(update)
+
+	return unit, update
+}
+
+// pressureAt returns a binding following either the sea-level or the station
+// pressure depending on the selected reference. The listener added to the
+// reference setting is returned, to be removed once the binding is not used anymore.
+func (u *unitSettings) pressureAt(seaLevel, station binding.Float) (binding.Float, binding.DataListener) {
+	pressure := binding.NewFloat()
+
+	update := binding.NewDataListener(func() {
+		source := seaLevel
+		if u.pressureReferenceName() == pressureStation {
+			source = station
+		}
+
+		v, err := source.Get()
+		if err != nil {
+			return
+		}
+		pressure.Set(v)
+	})
+	seaLevel.AddListener(update)
+	station.AddListener(update)
+	u.pressureReference.AddListener(update)
+
+	return pressure, update
+}
+
+// format returns a function printing a value converted to the selected unit.
+func (q *unitQuantity) format(verb string) func(float64) string {
+	return func(v float64) string {
+		unit := q.get()
+		return fmt.Sprintf(verb+" %s", q.convert(v, unit), unit)
+	}
+}
+
+func (u *unitSettings) system() string {
+	metric, imperial := true, true
+	for _, q := range u.quantities() {
+		unit := q.get()
+		metric = metric && unit == q.units[0]
+		imperial = imperial && unit == q.imperial
+	}
+
+	switch {
+	case metric:
+		return unitSystemMetric
+	case imperial:
+		return unitSystemImperial
+	}
+	return unitSystemCustom
+}
+
+func (u *unitSettings) pressureReferenceName() string {
+	reference, _ := u.pressureReference.Get()
+	return reference
+}
+
+// pressureField returns the observation field of the selected pressure reference.
+func (u *unitSettings) pressureField() string {
+	if u.pressureReferenceName() == pressureStation {
+		return "station_pressure"
+	}
+	return "sealevel_pressure"
+}
+
+func (u *unitSettings) save() {
+	for _, q := range u.quantities() {
+		u.prefs.SetString(q.key(), q.get())
+	}
+
+	u.prefs.SetString(pressureReferenceKey, u.pressureReferenceName())
+}
+
+func convertTemperature(celsius float64, unit string) float64 {
+	if unit == "°F" {
+		return celsius*9/5 + 32
+	}
+	return celsius
+}
+
+func convertSpeed(kph float64, unit string) float64 {
+	switch unit {
+	case "mph":
+		return kph / 1.609344
+	case "m/s":
+		return kph / 3.6
+	case "kn":
+		return kph / 1.852
+	}
+	return kph
+}
+
+func convertPressure(hpa float64, unit string) float64 {
+	switch unit {
+	case "inHg":
+		return hpa * 0.0295299830714
+	case "mmHg":
+		return hpa * 0.750061683
+	}
+	return hpa
+}
+
+func convertRain(mm float64, unit string) float64 {
+	if unit == "in" {
+		return mm / 25.4
+	}
+	return mm
+}
+
+func convertDistance(km float64, unit string) float64 {
+	if unit == "mi" {
+		return km / 1.609344
+	}
+	return km
+}
+
+// unitFormItems returns the settings form items of the units and the function applying them.
+func (app *application) unitFormItems() ([]*widget.FormItem, func()) {
+	selects := map[*unitQuantity]*widget.Select{}
+	items := []*widget.FormItem{}
+
+	system := widget.NewSelect([]string{unitSystemMetric, unitSystemImperial, unitSystemCustom}, nil)
+	items = append(items, &widget.FormItem{Text: "Units", Widget: system, HintText: "Pick a system, then override single quantities"})
+
+	for _, q := range app.units.quantities() {
+		s := widget.NewSelect(q.units, nil)
+		s.SetSelected(q.get())
+		selects[q] = s
+		items = append(items, &widget.FormItem{Text: q.name, Widget: s})
+	}
+
+	reference := widget.NewSelect([]string{pressureSeaLevel, pressureStation}, nil)
+	reference.SetSelected(app.units.pressureReferenceName())
+	items = append(items, &widget.FormItem{Text: "Pressure at", Widget: reference, HintText: "Pressure shown on the cards"})
+
+	system.SetSelected(app.units.system())
+	system.OnChanged = func(name string) {
+		for q, s := range selects {
+			switch name {
+			case unitSystemMetric:
+				s.SetSelected(q.units[0])
+			case unitSystemImperial:
+				s.SetSelected(q.imperial)
+			}
+		}
+	}
+
+	return items, func() {
+		for q, s := range selects {
+			q.set(s.Selected)
+		}
+		app.units.pressureReference.Set(reference.Selected)
+		app.units.save()
+
+		for _, card := range app.cardList() {
+			card.refreshSparklines()
+		}
+	}
+}
@@ -1,22 +1,43 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
-	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	xbinding "fyne.io/x/fyne/data/binding"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// sparklinePressure is the sparkline of the pressure at the selected reference.
+const sparklinePressure = "pressure"
+
 type weatherCard struct {
-	client mqtt.Client
-	cancel chan struct{}
-	stop   bool
+	serial string
+	source xbinding.StringCloser
+	status xbinding.StringCloser
+
+	// fieldTopics replaces source when every field has its own topic
+	fieldTopics *fieldTopics
+	units       *unitSettings
+	history     *observationHistory
+	store       *observationStore
+	stats       *dailyStats
+	alerts      *alertEngine
+	mapper      *mapper
+	notify      func(alertEvent)
+	staleAfter  func() time.Duration
 
+	title       *widget.Label
+	condition   *canvas.Image
+	compass     *windCompass
 	temperature *widget.Label
 	humidity    *widget.Label
 	pressure    *widget.Label
@@ -24,134 +45,562 @@ type weatherCard struct {
 	uv          *widget.Label
 	rain        *widget.Label
 
-	action  *widget.Button
+	// conditionSource is the observation the condition listener is added to
+	conditionSource   xbinding.JSONValue
+	conditionListener binding.DataListener
+	shared            []sharedListener
+
+	sparklines map[string]*lineChart
+	today      *statsPanel
+	lightning  *lightningPanel
+
+	details      map[string]*widget.Label
+	todayItem    *widget.AccordionItem
+	sectionItems []*widget.AccordionItem
+	sections     *widget.Accordion
+
+	// freshness protects the reception times and the overlay state, updated
+	// from the MQTT and freshness goroutines
+	freshness       sync.Mutex
+	lastObservation time.Time
+	lastStatus      time.Time
+	live            bool // subscribed to the station, its observation can go stale
+	updated         *widget.Label
+	enabled, stale  bool
+
+	mapping *widget.Button
+	remove  *widget.Button
 	overlay *canvas.Rectangle
+	content fyne.CanvasObject
 }
 
-func (app *application) newWeatherCard() *weatherCard {
-	return &weatherCard{temperature: widget.NewLabel("-°C, feels like -°C"),
-		humidity: widget.NewLabel("-%"),
-		pressure: widget.NewLabel("-"),
-		wind:     widget.NewLabel("- kph (- kph) from -°"),
-		uv:       widget.NewLabel("-"),
-		rain:     widget.NewLabel("-"),
-		action: widget.NewButton("Connect", func() {
-			if app.card.client != nil {
-				app.card.stopMqtt(nil)
-			}
+func stationTitle(serial string) string {
+	if serial == "" {
+		return "No station selected"
+	}
+	return "Station ST-" + serial
+}
 
-			app.connectionDialogShow()
-		}),
-		overlay: canvas.NewRectangle(disableColor),
+func (app *application) newWeatherCard(serial string) *weatherCard {
+	card := &weatherCard{serial: serial, units: app.units, history: newObservationHistory(app.historyRetention()), store: app.store, stats: app.loadDailyStats(serial),
+		alerts:      newAlertEngine(app.alertRules()),
+		mapper:      &mapper{mapping: app.loadStationMapping(serial)},
+		title:       widget.NewLabelWithStyle(stationTitle(serial), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		temperature: widget.NewLabel("-°C, feels like -°C"),
+		humidity:    widget.NewLabel("-%"),
+		pressure:    widget.NewLabel("-"),
+		wind:        widget.NewLabel("- kph (- kph) from -°"),
+		uv:          widget.NewLabel("-"),
+		rain:        widget.NewLabel("-"),
+		condition:   canvas.NewImageFromResource(nil),
+		compass:     newWindCompass(),
+		updated:     widget.NewLabel(""),
+		overlay:     canvas.NewRectangle(disableColor),
 	}
+	card.updated.Importance = widget.LowImportance
+	card.condition.FillMode = canvas.ImageFillContain
+	card.condition.SetMinSize(fyne.NewSize(64, 64))
+	card.notify = func(event alertEvent) { app.notifyAlert(card.serial, event) }
+	card.staleAfter = app.staleAfter
+	card.mapping = widget.NewButtonWithIcon("", theme.SettingsIcon(), func() {
+		app.fieldMappingDialogShow(card)
+	})
+	if serial == "" {
+		card.mapping.Disable()
+	}
+	if name := card.mapper.get().Name; name != "" {
+		card.title.SetText(name)
+	}
+	card.remove = widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		app.removeStation(card)
+	})
+
+	card.sparklines = map[string]*lineChart{}
+	for _, field := range []struct {
+		key, title string
+		format     func(float64) string
+	}{
+		{"air_temperature", "Temperature", app.units.temperature.format("%.1f")},
+		{"relative_humidity", "Humidity", func(v float64) string { return fmt.Sprintf("%.1f%%", v) }},
+		{sparklinePressure, "Pressure", app.units.pressure.format("%.2f")},
+		{"wind_speed", "Wind speed", app.units.speed.format("%.1f")},
+	} {
+		card.sparklines[field.key] = newSparkline(func() {
+			app.historyChartShow(stationTitle(card.serial)+" "+field.title, card.history.samples(card.sparklineField(field.key)), field.format)
+		})
+	}
+
+	card.lightning = newLightningPanel(app.units)
+	card.today = newStatsPanel(card.stats, app.units)
+	card.today.refresh()
+
+	card.content = card.makeWeatherCard(app.hiddenSections())
+	card.restore()
+
+	return card
 }
 
-func (card *weatherCard) makeWeatherCard() fyne.CanvasObject {
-	return container.NewVBox(container.NewMax(container.New(layout.NewFormLayout(), widget.NewLabel("Temperature:"), card.temperature,
-		widget.NewLabel("Humidity:"), card.humidity,
-		widget.NewLabel("Pressure:"), card.pressure,
-		widget.NewLabel("Wind:"), card.wind,
-		widget.NewLabel("UV:"), card.uv,
-		widget.NewLabel("Rain:"), card.rain),
-		card.overlay),
-		layout.NewSpacer(),
-		container.NewHBox(layout.NewSpacer(), card.action))
+func (card *weatherCard) makeWeatherCard(hidden map[string]bool) fyne.CanvasObject {
+	body := container.NewVBox(
+		container.NewMax(container.NewBorder(nil, nil, container.NewCenter(card.condition), nil,
+			container.New(layout.NewFormLayout(), widget.NewLabel("Temperature:"), card.withSparkline(card.temperature, "air_temperature"),
+				widget.NewLabel("Humidity:"), card.withSparkline(card.humidity, "relative_humidity"),
+				widget.NewLabel("Pressure:"), card.withSparkline(card.pressure, sparklinePressure),
+				widget.NewLabel("Wind:"), card.withSparkline(card.wind, "wind_speed"),
+				widget.NewLabel("UV:"), card.uv,
+				widget.NewLabel("Rain:"), card.rain)),
+			card.overlay),
+		container.NewCenter(card.compass),
+		card.makeSections(hidden))
+
+	return container.NewBorder(container.NewBorder(nil, nil, nil, container.NewHBox(card.updated, card.mapping, card.remove), card.title), nil, nil, nil,
+		container.NewVScroll(body))
+}
+
+func (card *weatherCard) withSparkline(value *widget.Label, key string) fyne.CanvasObject {
+	return container.NewBorder(nil, nil, nil, card.sparklines[key], value)
 }
 
 func (card *weatherCard) Enable() {
-	card.overlay.FillColor = enableColor
-	card.overlay.Refresh()
+	card.freshness.Lock()
+	card.enabled = true
+	card.freshness.Unlock()
+
+	card.refreshOverlay()
 }
 
 func (card *weatherCard) Disable() {
-	card.overlay.FillColor = disableColor
+	card.freshness.Lock()
+	card.enabled = false
+	card.freshness.Unlock()
+
+	card.refreshOverlay()
+}
+
+// refreshOverlay greys out the card while it is disconnected or its data is stale.
+func (card *weatherCard) refreshOverlay() {
+	card.freshness.Lock()
+	live := card.enabled && !card.stale
+	card.freshness.Unlock()
+
+	if live {
+		card.overlay.FillColor = enableColor
+	} else {
+		card.overlay.FillColor = disableColor
+	}
 	card.overlay.Refresh()
 }
 
-func (card *weatherCard) connectWeather2Mqtt(serial string) (xbinding.JSONValue, error) {
-	mqtt, err := xbinding.NewMqttString(card.client, "homeassistant/sensor/weatherflow2mqtt_ST-"+serial+"/observation/state")
+func (card *weatherCard) connectWeather2Mqtt(client mqttClient, topics stationTopics) (xbinding.JSONValue, error) {
+	var observation binding.String
+	if mapping := card.mapper.get(); mapping.Mode == mappingTopics {
+		fields, err := card.bindFieldTopics(client, mapping.Fields)
+		if err != nil {
+			return nil, err
+		}
+		observation = fields
+	} else {
+		mqtt, err := newMqttString(client, topics.observationTopic(card.serial))
+		if err != nil {
+			return nil, err
+		}
+		card.source = mqtt
+
+		// Payloads of other station software are translated to weatherflow2mqtt ones
+		observation = card.mapper.bind(mqtt)
+	}
+
+	json, err := xbinding.NewJSONFromString(observation)
 	if err != nil {
 		return nil, err
 	}
-	json, err := xbinding.NewJSONFromString(mqtt)
-	if err != nil {
+
+	if err := card.bindObservation(json); err != nil {
 		return nil, err
 	}
+	card.bindHistory(json, observation)
+
+	card.freshness.Lock()
+	card.live = true
+	card.freshness.Unlock()
+
+	// Devices announced to Home Assistant have no status to follow
+	if card.mapper.get().Origin != originHADiscovery {
+		if err := card.bindStatus(client, topics); err != nil {
+			return nil, err
+		}
+	}
+
+	return json, nil
+}
+
+// bindObservation displays the observation of the JSON binding on the card.
+func (card *weatherCard) bindObservation(json xbinding.JSONValue) error {
 	temperature, err := json.GetItemFloat("air_temperature")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	temperatureFeel, err := json.GetItemFloat("feelslike")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	temperatureLabel := binding.NewSprintf("%.2f°C, feels like %.2f°C", temperature, temperatureFeel)
+	temperatureUnit := card.units.temperature
+	temperatureLabel := binding.NewSprintf("%.2f%s, feels like %.2f%s",
+		card.convert(temperatureUnit, temperature), card.unit(temperatureUnit), card.convert(temperatureUnit, temperatureFeel), card.unit(temperatureUnit))
 
 	humidity, err := json.GetItemFloat("relative_humidity")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	humidityLabel := binding.FloatToStringWithFormat(humidity, "%.1f%%")
 
-	pressure, err := json.GetItemString("pressure_trend")
+	seaLevelPressure, err := json.GetItemFloat("sealevel_pressure")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
+	stationPressure, err := json.GetItemFloat("station_pressure")
+	if err != nil {
+		return err
+	}
+
+	pressureTrend, err := json.GetItemString("pressure_trend")
+	if err != nil {
+		return err
+	}
+
+	pressureTrendValue, err := json.GetItemFloat("pressure_trend_value")
+	if err != nil {
+		return err
+	}
+
+	pressureUnit := card.units.pressure
+	pressureLabel := binding.NewSprintf("%.2f %s %s (%+.2f %s/3h)",
+		card.convert(pressureUnit, card.pressureAt(seaLevelPressure, stationPressure)), card.unit(pressureUnit),
+		trendArrow(pressureTrend), card.convert(pressureUnit, pressureTrendValue), card.unit(pressureUnit))
+
 	windSpeed, err := json.GetItemFloat("wind_speed")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	windBurst, err := json.GetItemFloat("wind_gust")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	windDirection, err := json.GetItemFloat("wind_direction")
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	speedUnit := card.units.speed
+	windLabel := binding.NewSprintf("%.2f %s (%.2f %s) from %.2f°",
+		card.convert(speedUnit, windSpeed), card.unit(speedUnit), card.convert(speedUnit, windBurst), card.unit(speedUnit), windDirection)
+
+	windLull, err := json.GetItemFloat("wind_lull")
+	if err != nil {
+		return err
+	}
+
+	windAverage, err := json.GetItemFloat("wind_bearing_avg")
+	if err != nil {
+		return err
 	}
 
-	windLabel := binding.NewSprintf("%.2f kph (%.2f kph) from %.2f°", windSpeed, windBurst, windDirection)
+	card.compass.Bind(windDirection, windAverage,
+		binding.NewSprintf("%.1f %s", card.convert(speedUnit, windSpeed), card.unit(speedUnit)),
+		binding.NewSprintf("gust %.1f %s, lull %.1f %s", card.convert(speedUnit, windBurst), card.unit(speedUnit),
+			card.convert(speedUnit, windLull), card.unit(speedUnit)))
 
 	uv, err := json.GetItemString("uv_description")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	rain, err := json.GetItemString("rain_intensity")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	card.temperature.Bind(temperatureLabel)
 	card.humidity.Bind(humidityLabel)
-	card.pressure.Bind(pressure)
+	card.pressure.Bind(pressureLabel)
 	card.wind.Bind(windLabel)
 	card.uv.Bind(uv)
 	card.rain.Bind(rain)
 
-	return json, nil
+	if err := card.bindCondition(json); err != nil {
+		return err
+	}
+	return card.bindSections(json)
 }
 
-func (card *weatherCard) stopMqtt(d dialog.Dialog) {
-	if card.client.IsConnected() {
-		card.client.Unsubscribe("homeassistant/sensor/+/status/attributes")
+// sharedListener is a listener added by the card to a setting of the whole
+// application, removed when the card is disconnected.
+type sharedListener struct {
+	item     binding.DataItem
+	listener binding.DataListener
+}
+
+// convert returns source converted to the unit selected for q.
+func (card *weatherCard) convert(q *unitQuantity, source binding.Float) binding.Float {
+	converted, listener := q.convertFloat(source)
+	card.shared = append(card.shared, sharedListener{q.unit, listener})
+	return converted
+}
+
+// pressureAt returns the pressure at the selected reference.
+func (card *weatherCard) pressureAt(seaLevel, station binding.Float) binding.Float {
+	pressure, listener := card.units.pressureAt(seaLevel, station)
+	card.shared = append(card.shared, sharedListener{card.units.pressureReference, listener})
+	return pressure
+}
+
+// unit returns the unit selected for q, to be formatted next to a converted value.
+func (card *weatherCard) unit(q *unitQuantity) binding.String {
+	unit, listener := q.follow()
+	card.shared = append(card.shared, sharedListener{q.unit, listener})
+	return unit
+}
+
+// bindCondition updates the condition icon whenever an observation is received.
+func (card *weatherCard) bindCondition(json xbinding.JSONValue) error {
+	texts := map[string]binding.String{"precipitation_type": nil, "rain_intensity": nil}
+	for key := range texts {
+		item, err := json.GetItemString(key)
+		if err != nil {
+			return err
+		}
+		texts[key] = item
+	}
+
+	numbers := map[string]binding.Float{"air_temperature": nil, "lightning_strike_count_1hr": nil, "lightning_strike_distance": nil, "solar_radiation": nil,
+		"illuminance": nil, "wind_speed": nil, "beaufort": nil}
+	for key := range numbers {
+		item, err := json.GetItemFloat(key)
+		if err != nil {
+			return err
+		}
+		numbers[key] = item
+	}
+
+	float := func(key string) float64 {
+		f, _ := numbers[key].Get()
+		return f
+	}
+	str := func(key string) string {
+		s, _ := texts[key].Get()
+		return s
+	}
+
+	// The items are listening to json too, they are updated before this listener runs
+	listener := binding.NewDataListener(func() {
+		if json.IsEmpty() {
+			return
+		}
+
+		c := deriveCondition(conditionObservation{
+			PrecipitationType: str("precipitation_type"),
+			RainIntensity:     str("rain_intensity"),
+			AirTemperature:    float("air_temperature"),
+			LightningCount1h:  float("lightning_strike_count_1hr"),
+			LightningDistance: float("lightning_strike_distance"),
+			SolarRadiation:    float("solar_radiation"),
+			Illuminance:       float("illuminance"),
+			WindSpeed:         float("wind_speed"),
+			Beaufort:          float("beaufort"),
+			Time:              time.Now(),
+		})
+
+		card.condition.Resource = c.icon()
+		card.condition.Refresh()
+	})
+
+	json.AddListener(listener)
+	card.conditionSource, card.conditionListener = json, listener
+	return nil
+}
+
+// bindHistory records every observation received and updates the sparklines.
+func (card *weatherCard) bindHistory(json xbinding.JSONValue, source binding.String) {
+	json.AddListener(binding.NewDataListener(func() {
+		if json.IsEmpty() {
+			return
+		}
+
+		payload, err := source.Get()
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+		card.touch(&card.lastObservation)
+
+		values := numericFields(payload)
+		card.history.record(now, values)
+		card.refreshSparklines()
+
+		for _, event := range card.alerts.evaluate(now, values) {
+			card.notify(event)
+		}
+		card.highlight(card.alerts.active())
+
+		card.stats.update(now, payload)
+		card.today.refresh()
+		card.lightning.update(now, payload)
+
+		if err := card.store.append(card.serial, now, payload); err != nil {
+			fyne.LogError("Unable to store observation", err)
+		}
+	}))
+}
+
+// highlight marks the rows showing a field with an alert currently raised.
+func (card *weatherCard) highlight(fields map[string]bool) {
+	rows := map[*widget.Label]bool{}
+	for key, label := range card.details {
+		rows[label] = fields[key]
+	}
+	for label, keys := range map[*widget.Label][]string{
+		card.temperature: {"air_temperature", "feelslike"},
+		card.humidity:    {"relative_humidity"},
+		card.pressure:    {"sealevel_pressure", "station_pressure"},
+		card.wind:        {"wind_speed", "wind_gust", "wind_lull", "wind_direction"},
+		card.uv:          {"uv"},
+		card.rain:        {"rain_rate", "rain_today"},
+	} {
+		for _, key := range keys {
+			rows[label] = rows[label] || fields[key]
+		}
+	}
+
+	for label, alert := range rows {
+		importance := widget.MediumImportance
+		if alert {
+			importance = widget.DangerImportance
+		}
+		if label.Importance != importance {
+			label.Importance = importance
+			label.Refresh()
+		}
+	}
+}
 
-		card.temperature.Unbind()
-		card.humidity.Unbind()
-		card.pressure.Unbind()
-		card.wind.Unbind()
-		card.uv.Unbind()
-		card.rain.Unbind()
+func (card *weatherCard) refreshSparklines() {
+	for key, spark := range card.sparklines {
+		spark.SetSamples(card.history.samples(card.sparklineField(key)))
 	}
-	card.action.SetText("Connect")
-	card.client.Disconnect(0)
-	card.client = nil
-	if d != nil {
-		d.Hide()
+}
+
+// sparklineField returns the observation field drawn by a sparkline, the
+// pressure one follows the selected reference.
+func (card *weatherCard) sparklineField(key string) string {
+	if key == sparklinePressure {
+		return card.units.pressureField()
+	}
+	return key
+}
+
+// restore fills the history of the card from the observations stored on disk
+// and shows the last one until live data arrives.
+func (card *weatherCard) restore() {
+	if card.serial == "" {
+		return
+	}
+
+	now := time.Now()
+	records, err := card.store.read(card.serial, now.Add(-card.history.retention), now)
+	if err != nil {
+		fyne.LogError("Unable to read stored observations", err)
+	}
+	if len(records) == 0 {
+		return
 	}
+
+	for _, r := range records {
+		card.history.record(r.Time, numericFields(string(r.Payload)))
+	}
+	card.lastObservation = records[len(records)-1].Time
+	card.refreshSparklines()
+
+	last := binding.NewString()
+	last.Set(string(records[len(records)-1].Payload))
+
+	json, err := xbinding.NewJSONFromString(last)
+	if err != nil {
+		return
+	}
+	if err := card.bindObservation(json); err != nil {
+		fyne.LogError("Unable to show stored observation", err)
+	}
+}
+
+// trendArrow turns the pressure trend description into an arrow.
+func trendArrow(trend binding.String) binding.String {
+	arrow := binding.NewString()
+
+	trend.AddListener(binding.NewDataListener(func() {
+		t, _ := trend.Get()
+
+		switch strings.ToLower(t) {
+		case "rising":
+			arrow.Set("↑")
+		case "falling":
+			arrow.Set("↓")
+		case "steady":
+			arrow.Set("→")
+		default:
+			arrow.Set(t)
+		}
+	}))
+
+	return arrow
+}
+
+// release stops the card for good once it is no longer displayed.
+func (card *weatherCard) release() {
+	card.disconnect()
+	card.today.close()
+}
+
+// disconnect stops updating the card from its station observation topic.
+func (card *weatherCard) disconnect() {
+	card.temperature.Unbind()
+	card.humidity.Unbind()
+	card.pressure.Unbind()
+	card.wind.Unbind()
+	card.uv.Unbind()
+	card.rain.Unbind()
+	card.unbindSections()
+	card.compass.Unbind()
+	if card.conditionSource != nil {
+		card.conditionSource.RemoveListener(card.conditionListener)
+		card.conditionSource, card.conditionListener = nil, nil
+	}
+	for _, l := range card.shared {
+		l.item.RemoveListener(l.listener)
+	}
+	card.shared = nil
+
+	if card.source != nil {
+		card.source.Close()
+		card.source = nil
+	}
+	if card.fieldTopics != nil {
+		card.fieldTopics.close()
+		card.fieldTopics = nil
+	}
+
+	card.freshness.Lock()
+	if card.status != nil {
+		card.status.Close()
+		card.status = nil
+	}
+	card.live, card.stale = false, false
+	card.freshness.Unlock()
+
+	card.Disable()
 }